@@ -0,0 +1,510 @@
+// Package build builds and releases the applications pushed to the builder.
+//
+// A build checks out the pushed commit, fetches the application's config from the controller,
+// compiles a slug with the slugbuilder unless the application has a Dockerfile, builds and
+// pushes its image, and publishes a release to the controller.
+package build
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/deis/deis/builder"
+)
+
+const (
+	slugbuilderImage string = "deis/slugbuilder"
+	slugrunnerImage  string = "deis/slugrunner"
+	// slugFile is the slug compiled by the slugbuilder, relative to the source.
+	slugFile string = "slug.tgz"
+	// SlugGroup is the group the slugbuilder runs as.
+	SlugGroup int = 2000
+	// DefaultBranch is the branch deployed unless the application sets DEIS_DEPLOY_BRANCH.
+	DefaultBranch string = "master"
+	// zeroSha is the sha git gives a deleted ref.
+	zeroSha string = "0000000000000000000000000000000000000000"
+)
+
+// ErrCancelled is returned by a build which was cancelled.
+var ErrCancelled = errors.New("build cancelled")
+
+// Build is a push of an application being built.
+type Build struct {
+	// ID is the UUID of the build, shared by its release and its log.
+	ID   string
+	User string
+	App  string
+	// Sha is the pushed commit.
+	Sha string
+	// Ref is the pushed ref, e.g. refs/heads/master, or empty if it is not known.
+	Ref string
+	// RepoDir is the application's bare git repository.
+	RepoDir string
+	// Dir is where the pushed commit is checked out and built.
+	Dir string
+	// CacheDir is the slugbuilder's cache, shared by the builds of the application.
+	CacheDir string
+
+	Config *builder.Config
+	// Dockerfile is true if the application is built from its own Dockerfile.
+	Dockerfile bool
+	// Image is the image pushed to the registry.
+	Image    string
+	Procfile builder.ProcessType
+	Release  *builder.BuildHookResponse
+
+	// container is the slugbuilder container, if any.
+	container string
+}
+
+// NewBuild returns a build of sha pushed by user to repo, a repository in gitHome.
+func NewBuild(user, repo, sha, gitHome string) *Build {
+	repoDir := filepath.Join(gitHome, repo)
+	return &Build{
+		ID:       newUUID(),
+		User:     user,
+		App:      strings.TrimSuffix(repo, filepath.Ext(repo)),
+		Sha:      sha,
+		RepoDir:  repoDir,
+		CacheDir: filepath.Join(repoDir, "cache"),
+	}
+}
+
+// ShortSha returns the abbreviated commit.
+func (b *Build) ShortSha() string {
+	if len(b.Sha) > 8 {
+		return b.Sha[:8]
+	}
+	return b.Sha
+}
+
+// Builder runs builds.
+type Builder struct {
+	Controller Controller
+	Docker     Docker
+	// Registry is the host:port of the registry images are pushed to.
+	Registry string
+	// SlugGroup is given access to the source and cache, or -1 to leave their group unchanged.
+	SlugGroup int
+	// Out receives the output shown to the user.
+	Out io.Writer
+	// Cancel stops the build between steps when it is closed.
+	Cancel <-chan struct{}
+}
+
+// Run builds and releases b. The build's directory and container are removed once it is done.
+func (r *Builder) Run(b *Build) error {
+	if err := r.Checkout(b); err != nil {
+		return err
+	}
+	defer r.Cleanup(b)
+
+	steps := []func(*Build) error{
+		r.FetchConfig,
+		r.Compile,
+		r.BuildImage,
+		r.PushImage,
+		r.ExtractProcfile,
+		r.Publish,
+	}
+	for _, step := range steps {
+		select {
+		case <-r.Cancel:
+			return ErrCancelled
+		default:
+		}
+		if err := step(b); err != nil {
+			return err
+		}
+	}
+
+	version := b.Release.Release["version"]
+	r.indent("done, %s:v%d deployed to Deis", b.App, version)
+	fmt.Fprintln(r.Out)
+	if len(b.Release.Domains) > 0 {
+		r.indent("http://%s", b.Release.Domains[0])
+		fmt.Fprintln(r.Out)
+	}
+	r.indent("To learn more, use `deis help` or visit http://deis.io")
+	fmt.Fprintln(r.Out)
+	return nil
+}
+
+// CheckRef returns whether b was pushed to the deployed branch of the application, and should
+// be built. Pushes to other refs are kept in the repository without being deployed, and the
+// deployed branch cannot be deleted. A build without a ref is always built.
+//
+// It fetches the application's config, which names the deployed branch.
+func (r *Builder) CheckRef(b *Build) (bool, error) {
+	if b.Ref == "" {
+		return true, nil
+	}
+	if err := r.FetchConfig(b); err != nil {
+		return false, err
+	}
+	branch := b.DeployBranch()
+	deployed := b.Ref == "refs/heads/"+branch
+	if b.Sha == zeroSha {
+		if deployed {
+			return false, fmt.Errorf("%s is the deployed branch of %s and cannot be deleted", branch, b.App)
+		}
+		// deleting any other ref leaves the application as it is
+		return false, nil
+	}
+	if !deployed {
+		r.step(fmt.Sprintf("Not deploying %s, only the %s branch of %s is deployed", b.Ref, branch, b.App))
+		r.indent("Set DEIS_DEPLOY_BRANCH with `deis config:set` to deploy another branch")
+		return false, nil
+	}
+	return true, nil
+}
+
+// DeployBranch returns the branch of the application which is deployed: DEIS_DEPLOY_BRANCH, or
+// master. The config must have been fetched.
+func (b *Build) DeployBranch() string {
+	if b.Config != nil {
+		if branch, ok := b.Config.Values["DEIS_DEPLOY_BRANCH"].(string); ok && branch != "" {
+			return strings.TrimPrefix(branch, "refs/heads/")
+		}
+	}
+	return DefaultBranch
+}
+
+// Checkout extracts the pushed commit into a new directory under the repository's build
+// directory.
+func (r *Builder) Checkout(b *Build) error {
+	buildDir := filepath.Join(b.RepoDir, "build")
+	for _, dir := range []string{buildDir, b.CacheDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	dir, err := ioutil.TempDir(buildDir, "")
+	if err != nil {
+		return err
+	}
+	b.Dir = dir
+
+	cmd := exec.Command("git", "archive", b.Sha)
+	cmd.Dir = b.RepoDir
+	archive, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := extract(archive, dir); err != nil {
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git archive %s: %v", b.Sha, err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "Dockerfile"))
+	b.Dockerfile = err == nil
+	return nil
+}
+
+// FetchConfig fetches the application's config from the controller, unless it was already
+// fetched.
+func (r *Builder) FetchConfig(b *Build) error {
+	if b.Config != nil {
+		return nil
+	}
+	config, err := r.Controller.Config(b.User, b.App)
+	if err != nil {
+		return err
+	}
+	b.Config = config
+	return nil
+}
+
+// Compile compiles the source into a slug with the slugbuilder, and writes a Dockerfile which
+// runs the slug. Applications with their own Dockerfile are not compiled.
+func (r *Builder) Compile(b *Build) error {
+	if b.Dockerfile {
+		return nil
+	}
+	if err := r.shareWithSlugbuilder(b); err != nil {
+		return err
+	}
+
+	env := []string{"SOURCE_VERSION=" + b.Sha}
+	if b.Config != nil {
+		keys := make([]string, 0, len(b.Config.Values))
+		for key := range b.Config.Values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			env = append(env, fmt.Sprintf("%s=%v", key, b.Config.Values[key]))
+		}
+	}
+	id, err := r.Docker.Run(RunOptions{
+		Image: slugbuilderImage,
+		Env:   env,
+		Volumes: []string{
+			"/etc/environment_proxy:/etc/environment_proxy",
+			b.Dir + ":/tmp/app",
+			b.CacheDir + ":/tmp/cache:rw",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	b.container = id
+	if err := r.Docker.Attach(id, r.Out); err != nil {
+		return err
+	}
+	if err := r.Docker.CopyFrom(id, "/tmp/"+slugFile, b.Dir); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(b.Dir, "Dockerfile"), []byte("FROM "+slugrunnerImage+"\n"), 0644)
+}
+
+// shareWithSlugbuilder gives the slugbuilder's group access to the source and cache.
+func (r *Builder) shareWithSlugbuilder(b *Build) error {
+	if r.SlugGroup < 0 {
+		return nil
+	}
+	err := filepath.Walk(b.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, -1, r.SlugGroup)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Lchown(b.CacheDir, -1, r.SlugGroup); err != nil {
+		return err
+	}
+	for _, dir := range []string{b.Dir, b.CacheDir} {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(dir, info.Mode()|0070); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildImage builds the application's image from its Dockerfile. Applications with their own
+// Dockerfile get their build args, see BuildArgs.
+func (r *Builder) BuildImage(b *Build) error {
+	var buildArgs []string
+	if b.Dockerfile {
+		var err error
+		if buildArgs, err = r.BuildArgs(b); err != nil {
+			return err
+		}
+	}
+	dockerfile, err := os.OpenFile(filepath.Join(b.Dir, "Dockerfile"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	// inject builder-specific environment variables into the application environment
+	_, err = fmt.Fprintf(dockerfile, "\nENV GIT_SHA %s\n", b.Sha)
+	if closeErr := dockerfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	b.Image = fmt.Sprintf("%s/%s:git-%s", r.Registry, b.App, b.ShortSha())
+	fmt.Fprintln(r.Out)
+	r.step("Building Docker image")
+	return r.Docker.Build(b.Dir, b.Image, buildArgs, r.Out)
+}
+
+// BuildArgs returns the KEY=value build args of the application's Dockerfile: the config
+// values listed in DEIS_BUILD_ARGS which the Dockerfile declares with ARG.
+//
+// Docker keeps build args in the history of the image, and cannot give a build a value
+// without keeping it, so the build fails if it asks for a config value listed in
+// DEIS_BUILD_SECRETS, either in DEIS_BUILD_ARGS or with ARG. Build secrets are only given to
+// the slugbuilder.
+func (r *Builder) BuildArgs(b *Build) ([]string, error) {
+	declared, err := dockerfileArgs(filepath.Join(b.Dir, "Dockerfile"))
+	if err != nil {
+		return nil, err
+	}
+	listed := make(map[string]bool)
+	for _, key := range b.configKeys("DEIS_BUILD_ARGS") {
+		listed[key] = true
+	}
+	for _, key := range b.configKeys("DEIS_BUILD_SECRETS") {
+		if listed[key] || declared[key] {
+			return nil, fmt.Errorf("%s is a build secret, which a Dockerfile build cannot use without keeping it in the image", key)
+		}
+	}
+	var args, keys []string
+	for _, key := range b.configKeys("DEIS_BUILD_ARGS") {
+		value, ok := b.Config.Values[key]
+		if !ok {
+			r.indent("%s is not set, not passing it to the build", key)
+			continue
+		}
+		if !declared[key] {
+			r.indent("%s is not declared with ARG in the Dockerfile, not passing it to the build", key)
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s=%v", key, value))
+		keys = append(keys, key)
+	}
+	if len(keys) > 0 {
+		r.indent("Build args: %s", strings.Join(keys, ", "))
+	}
+	return args, nil
+}
+
+// configKeys returns the config keys listed in the config value name, separated by commas or
+// spaces.
+func (b *Build) configKeys(name string) []string {
+	if b.Config == nil {
+		return nil
+	}
+	list, _ := b.Config.Values[name].(string)
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// dockerfileArgs returns the names of the build args a Dockerfile declares with ARG.
+func dockerfileArgs(path string) (map[string]bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	args := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "ARG") {
+			continue
+		}
+		args[strings.SplitN(fields[1], "=", 2)[0]] = true
+	}
+	return args, nil
+}
+
+// PushImage pushes the application's image to the registry.
+func (r *Builder) PushImage(b *Build) error {
+	r.step("Pushing image to private registry")
+	return r.Docker.Push(b.Image)
+}
+
+// ExtractProcfile reads the application's process types.
+func (r *Builder) ExtractProcfile(b *Build) error {
+	procfile, err := readProcfile(b.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid Procfile: %v", err)
+	}
+	b.Procfile = procfile
+	return nil
+}
+
+// Publish creates a release of the build with the controller, which deploys it.
+func (r *Builder) Publish(b *Build) error {
+	r.step("Launching... ")
+	hook := &builder.BuildHook{
+		UUID:        b.ID,
+		Sha:         b.ShortSha(),
+		ReceiveUser: b.User,
+		ReceiveRepo: b.App,
+		Image:       b.App,
+		Procfile:    b.Procfile,
+	}
+	if b.Dockerfile {
+		hook.Dockerfile = "true"
+	}
+	resp, err := r.Controller.Publish(hook)
+	if err != nil {
+		return fmt.Errorf("failed to launch container: %v", err)
+	}
+	b.Release = resp
+	return nil
+}
+
+// Cleanup removes the build's directory and container.
+func (r *Builder) Cleanup(b *Build) {
+	gc := exec.Command("git", "gc")
+	gc.Dir = b.RepoDir
+	gc.Run()
+	if b.Dir != "" {
+		os.RemoveAll(b.Dir)
+	}
+	if b.container != "" {
+		r.Docker.Remove(b.container)
+	}
+}
+
+func (r *Builder) step(msg string) {
+	fmt.Fprintf(r.Out, "-----> %s\n", msg)
+}
+
+func (r *Builder) indent(format string, a ...interface{}) {
+	fmt.Fprintf(r.Out, "       "+format+"\n", a...)
+}
+
+// extract extracts a tar archive into dir.
+func extract(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			// git archive stores the commit in a pax header
+			continue
+		}
+		path := filepath.Join(dir, hdr.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		mode := hdr.FileInfo().Mode()
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(path, mode.Perm()|0700)
+		case mode&os.ModeSymlink != 0:
+			err = os.Symlink(hdr.Linkname, path)
+		case mode.IsRegular():
+			err = writeFile(path, tr, mode.Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,513 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deis/deis/builder"
+)
+
+type fakeDocker struct {
+	runs   []RunOptions
+	builds map[string]string
+	// buildArgs holds the build args of each image built.
+	buildArgs map[string][]string
+	pushes    []string
+	removed   []string
+	// slug is copied out of the slugbuilder.
+	slug map[string]string
+	// failAttach makes the slugbuilder fail.
+	failAttach bool
+}
+
+func (d *fakeDocker) Run(opts RunOptions) (string, error) {
+	d.runs = append(d.runs, opts)
+	return fmt.Sprintf("container%d", len(d.runs)), nil
+}
+
+func (d *fakeDocker) Attach(id string, out io.Writer) error {
+	fmt.Fprintln(out, "-----> Compiled slug")
+	if d.failAttach {
+		return fmt.Errorf("docker attach: exit status 1")
+	}
+	return nil
+}
+
+func (d *fakeDocker) CopyFrom(id, path, dir string) error {
+	if path != "/tmp/"+slugFile {
+		return fmt.Errorf("unexpected path %s", path)
+	}
+	f, err := os.Create(filepath.Join(dir, slugFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeSlug(f, d.slug)
+}
+
+func (d *fakeDocker) Build(dir, tag string, buildArgs []string, out io.Writer) error {
+	dockerfile, err := ioutil.ReadFile(filepath.Join(dir, "Dockerfile"))
+	if err != nil {
+		return err
+	}
+	if d.builds == nil {
+		d.builds = make(map[string]string)
+		d.buildArgs = make(map[string][]string)
+	}
+	d.builds[tag] = string(dockerfile)
+	d.buildArgs[tag] = buildArgs
+	return nil
+}
+
+func (d *fakeDocker) Push(tag string) error {
+	d.pushes = append(d.pushes, tag)
+	return nil
+}
+
+func (d *fakeDocker) Remove(id string) error {
+	d.removed = append(d.removed, id)
+	return nil
+}
+
+type fakeController struct {
+	config *builder.Config
+	hooks  []*builder.BuildHook
+}
+
+func (c *fakeController) Config(user, app string) (*builder.Config, error) {
+	if c.config == nil {
+		return nil, fmt.Errorf("failed retrieving config from controller: 404 NOT FOUND")
+	}
+	return c.config, nil
+}
+
+func (c *fakeController) Publish(hook *builder.BuildHook) (*builder.BuildHookResponse, error) {
+	c.hooks = append(c.hooks, hook)
+	return &builder.BuildHookResponse{
+		Release: map[string]int{"version": 2},
+		Domains: []string{hook.ReceiveRepo + ".example.com"},
+	}, nil
+}
+
+// writeSlug writes a gzipped tar of files.
+func writeSlug(w io.Writer, files map[string]string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		hdr := &tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// newRepo creates a bare repository named repo in gitHome with a commit of files, and returns
+// the commit.
+func newRepo(t *testing.T, gitHome, repo string, files map[string]string) string {
+	work, err := ioutil.TempDir("", "deis-build-work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+	for name, data := range files {
+		if err := writeFile(filepath.Join(work, name), strings.NewReader(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=deis", "GIT_AUTHOR_EMAIL=deis@example.com",
+			"GIT_COMMITTER_NAME=deis", "GIT_COMMITTER_EMAIL=deis@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git(work, "init", "-q")
+	git(work, "add", ".")
+	git(work, "commit", "-q", "-m", "initial commit")
+	git(gitHome, "clone", "-q", "--bare", work, repo)
+	return git(work, "rev-parse", "HEAD")
+}
+
+func newTestBuilder(docker *fakeDocker, controller *fakeController) (*Builder, *bytes.Buffer) {
+	out := new(bytes.Buffer)
+	return &Builder{
+		Controller: controller,
+		Docker:     docker,
+		Registry:   "registry.local:5000",
+		SlugGroup:  -1,
+		Out:        out,
+	}, out
+}
+
+func TestNewBuild(t *testing.T) {
+	b := NewBuild("alice", "go-app.git", "d1cdc9b51e2e4bd4b2e1b5e87a2fb9be4d4ebd6d", "/home/git")
+	if b.App != "go-app" {
+		t.Errorf("expected app go-app, got %s", b.App)
+	}
+	if b.RepoDir != "/home/git/go-app.git" {
+		t.Errorf("expected repository /home/git/go-app.git, got %s", b.RepoDir)
+	}
+	if b.CacheDir != "/home/git/go-app.git/cache" {
+		t.Errorf("expected cache /home/git/go-app.git/cache, got %s", b.CacheDir)
+	}
+	if b.ShortSha() != "d1cdc9b5" {
+		t.Errorf("expected short sha d1cdc9b5, got %s", b.ShortSha())
+	}
+	if !uuidRegexp.MatchString(b.ID) {
+		t.Errorf("expected a UUID, got %s", b.ID)
+	}
+	if other := NewBuild("alice", "go-app.git", b.Sha, "/home/git"); other.ID == b.ID {
+		t.Errorf("expected builds to have different UUIDs, got %s twice", b.ID)
+	}
+}
+
+func TestCheckRef(t *testing.T) {
+	sha := "d1cdc9b51e2e4bd4b2e1b5e87a2fb9be4d4ebd6d"
+	tests := []struct {
+		branch  string
+		sha     string
+		ref     string
+		build   bool
+		fails   bool
+		message string
+	}{
+		{"", sha, "", true, false, ""},
+		{"", sha, "refs/heads/master", true, false, ""},
+		{"", sha, "refs/heads/feature", false, false, "-----> Not deploying refs/heads/feature, only the master branch of go-app is deployed"},
+		{"", sha, "refs/tags/v1", false, false, "-----> Not deploying refs/tags/v1"},
+		{"production", sha, "refs/heads/production", true, false, ""},
+		{"refs/heads/production", sha, "refs/heads/production", true, false, ""},
+		{"production", sha, "refs/heads/master", false, false, "only the production branch"},
+		{"", zeroSha, "refs/heads/feature", false, false, ""},
+		{"", zeroSha, "refs/heads/master", false, true, ""},
+	}
+	for _, test := range tests {
+		values := map[string]interface{}{}
+		if test.branch != "" {
+			values["DEIS_DEPLOY_BRANCH"] = test.branch
+		}
+		r, out := newTestBuilder(&fakeDocker{}, &fakeController{config: &builder.Config{Values: values}})
+		b := NewBuild("alice", "go-app.git", test.sha, "/home/git")
+		b.Ref = test.ref
+		build, err := r.CheckRef(b)
+		if test.fails != (err != nil) {
+			t.Errorf("%v: expected failure %v, got %v", test, test.fails, err)
+		}
+		if build != test.build {
+			t.Errorf("%v: expected build %v, got %v", test, test.build, build)
+		}
+		if test.message == "" && out.Len() > 0 || !strings.Contains(out.String(), test.message) {
+			t.Errorf("%v: expected output %q, got %q", test, test.message, out)
+		}
+	}
+
+	// the deployed branch of a missing app is not known
+	r, _ := newTestBuilder(&fakeDocker{}, &fakeController{})
+	b := NewBuild("alice", "go-app.git", sha, "/home/git")
+	b.Ref = "refs/heads/master"
+	if _, err := r.CheckRef(b); err == nil {
+		t.Error("expected the ref check to fail without a config")
+	}
+}
+
+func TestRunSlug(t *testing.T) {
+	gitHome, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitHome)
+	sha := newRepo(t, gitHome, "go-app.git", map[string]string{
+		"main.go":     "package main\n",
+		"lib/util.go": "package lib\n",
+	})
+
+	docker := &fakeDocker{slug: map[string]string{
+		".release": "default_process_types:\n  web: bin/server\n",
+	}}
+	controller := &fakeController{config: &builder.Config{
+		Values: map[string]interface{}{"BUILDPACK_URL": "https://github.com/heroku/heroku-buildpack-go"},
+	}}
+	r, out := newTestBuilder(docker, controller)
+	b := NewBuild("alice", "go-app.git", sha, gitHome)
+	if err := r.Run(b); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	if len(docker.runs) != 1 {
+		t.Fatalf("expected the slugbuilder to run once, got %v", docker.runs)
+	}
+	run := docker.runs[0]
+	if run.Image != slugbuilderImage {
+		t.Errorf("expected image %s, got %s", slugbuilderImage, run.Image)
+	}
+	expectedEnv := []string{"SOURCE_VERSION=" + sha, "BUILDPACK_URL=https://github.com/heroku/heroku-buildpack-go"}
+	if fmt.Sprint(run.Env) != fmt.Sprint(expectedEnv) {
+		t.Errorf("expected env %v, got %v", expectedEnv, run.Env)
+	}
+	if run.Volumes[1] != b.Dir+":/tmp/app" || run.Volumes[2] != b.CacheDir+":/tmp/cache:rw" {
+		t.Errorf("unexpected volumes %v", run.Volumes)
+	}
+
+	image := "registry.local:5000/go-app:git-" + sha[:8]
+	expectedDockerfile := "FROM deis/slugrunner\n\nENV GIT_SHA " + sha + "\n"
+	if docker.builds[image] != expectedDockerfile {
+		t.Errorf("expected %s built from\n%s\ngot %v", image, expectedDockerfile, docker.builds)
+	}
+	if fmt.Sprint(docker.pushes) != fmt.Sprint([]string{image}) {
+		t.Errorf("expected %s to be pushed, got %v", image, docker.pushes)
+	}
+
+	if len(controller.hooks) != 1 {
+		t.Fatalf("expected one build hook, got %d", len(controller.hooks))
+	}
+	hook := controller.hooks[0]
+	expectedHook := builder.BuildHook{
+		UUID:        b.ID,
+		Sha:         sha[:8],
+		ReceiveUser: "alice",
+		ReceiveRepo: "go-app",
+		Image:       "go-app",
+		Procfile:    builder.ProcessType{"web": "bin/server"},
+	}
+	if fmt.Sprint(*hook) != fmt.Sprint(expectedHook) {
+		t.Errorf("expected build hook %v, got %v", expectedHook, *hook)
+	}
+
+	for _, line := range []string{
+		"-----> Compiled slug",
+		"-----> Building Docker image",
+		"-----> Launching... ",
+		"       done, go-app:v2 deployed to Deis",
+		"       http://go-app.example.com",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expected output to contain %q, got\n%s", line, out)
+		}
+	}
+
+	if _, err := os.Stat(b.Dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", b.Dir)
+	}
+	if fmt.Sprint(docker.removed) != "[container1]" {
+		t.Errorf("expected the slugbuilder to be removed, got %v", docker.removed)
+	}
+}
+
+func TestRunDockerfile(t *testing.T) {
+	gitHome, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitHome)
+	sha := newRepo(t, gitHome, "docker-app.git", map[string]string{
+		"Dockerfile": "FROM deis/base\nARG GREETING\nCMD [\"/bin/true\"]",
+		"Procfile":   "web: /bin/true\nworker: sleep 1\n",
+	})
+
+	docker := &fakeDocker{}
+	controller := &fakeController{config: &builder.Config{Values: map[string]interface{}{
+		"DEIS_BUILD_ARGS": "GREETING",
+		"GREETING":        "hello",
+	}}}
+	r, out := newTestBuilder(docker, controller)
+	if err := r.Run(NewBuild("alice", "docker-app.git", sha, gitHome)); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	if len(docker.runs) != 0 {
+		t.Errorf("expected the slugbuilder not to run, got %v", docker.runs)
+	}
+	image := "registry.local:5000/docker-app:git-" + sha[:8]
+	expectedDockerfile := "FROM deis/base\nARG GREETING\nCMD [\"/bin/true\"]\nENV GIT_SHA " + sha + "\n"
+	if docker.builds[image] != expectedDockerfile {
+		t.Errorf("expected %s built from\n%s\ngot %v", image, expectedDockerfile, docker.builds)
+	}
+	if fmt.Sprint(docker.buildArgs[image]) != "[GREETING=hello]" {
+		t.Errorf("expected build args [GREETING=hello], got %v", docker.buildArgs[image])
+	}
+	hook := controller.hooks[0]
+	if hook.Dockerfile != "true" {
+		t.Errorf("expected dockerfile true, got %q", hook.Dockerfile)
+	}
+	expectedProcfile := builder.ProcessType{"web": "/bin/true", "worker": "sleep 1"}
+	if fmt.Sprint(hook.Procfile) != fmt.Sprint(expectedProcfile) {
+		t.Errorf("expected procfile %v, got %v", expectedProcfile, hook.Procfile)
+	}
+	if len(docker.removed) != 0 {
+		t.Errorf("expected no containers to be removed, got %v", docker.removed)
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dockerfile := "FROM deis/base\nARG NODE_ENV=production\narg VERSION\nRUN npm install\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, out := newTestBuilder(&fakeDocker{}, &fakeController{})
+	b := NewBuild("alice", "docker-app.git", "d1cdc9b51e2e4bd4b2e1b5e87a2fb9be4d4ebd6d", "/home/git")
+	b.Dir = dir
+	b.Config = &builder.Config{Values: map[string]interface{}{
+		"DEIS_BUILD_ARGS":    "NODE_ENV, VERSION,PORT MISSING",
+		"DEIS_BUILD_SECRETS": "NPM_TOKEN",
+		"NODE_ENV":           "staging",
+		"VERSION":            `1.0 "beta"`,
+		"PORT":               5000,
+		"NPM_TOKEN":          "secret",
+	}}
+	args, err := r.BuildArgs(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"NODE_ENV=staging", `VERSION=1.0 "beta"`}
+	if fmt.Sprint(args) != fmt.Sprint(expected) {
+		t.Errorf("expected build args %q, got %q", expected, args)
+	}
+	for _, line := range []string{
+		"       PORT is not declared with ARG in the Dockerfile, not passing it to the build",
+		"       MISSING is not set, not passing it to the build",
+		"       Build args: NODE_ENV, VERSION",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expected output to contain %q, got\n%s", line, out)
+		}
+	}
+	if strings.Contains(out.String(), "secret\n") || strings.Contains(out.String(), "staging") {
+		t.Errorf("expected no config values in the output, got\n%s", out)
+	}
+}
+
+func TestBuildArgsSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, _ := newTestBuilder(&fakeDocker{}, &fakeController{})
+	b := NewBuild("alice", "docker-app.git", "d1cdc9b51e2e4bd4b2e1b5e87a2fb9be4d4ebd6d", "/home/git")
+	b.Dir = dir
+	for _, test := range []struct {
+		dockerfile, buildArgs string
+	}{
+		// the secret is listed as a build arg too
+		{"FROM deis/base\nARG VERSION\n", "NPM_TOKEN VERSION"},
+		// the Dockerfile asks for the secret
+		{"FROM deis/base\nARG NPM_TOKEN\n", ""},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(test.dockerfile), 0644); err != nil {
+			t.Fatal(err)
+		}
+		b.Config = &builder.Config{Values: map[string]interface{}{
+			"DEIS_BUILD_ARGS":    test.buildArgs,
+			"DEIS_BUILD_SECRETS": "NPM_TOKEN",
+			"VERSION":            "1.0",
+			"NPM_TOKEN":          "secret",
+		}}
+		args, err := r.BuildArgs(b)
+		if err == nil || !strings.Contains(err.Error(), "NPM_TOKEN is a build secret") {
+			t.Errorf("expected the build secret to be rejected, got %q, %v", args, err)
+		}
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	gitHome, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitHome)
+	sha := newRepo(t, gitHome, "go-app.git", map[string]string{"main.go": "package main\n"})
+
+	docker := &fakeDocker{failAttach: true}
+	controller := &fakeController{config: &builder.Config{}}
+	r, _ := newTestBuilder(docker, controller)
+	b := NewBuild("alice", "go-app.git", sha, gitHome)
+	if err := r.Run(b); err == nil {
+		t.Fatal("expected the build to fail")
+	}
+	if len(docker.builds) != 0 || len(controller.hooks) != 0 {
+		t.Errorf("expected the build to stop after the slugbuilder failed")
+	}
+	if _, err := os.Stat(b.Dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", b.Dir)
+	}
+	if fmt.Sprint(docker.removed) != "[container1]" {
+		t.Errorf("expected the slugbuilder to be removed, got %v", docker.removed)
+	}
+
+	// a missing app fails before anything is built
+	r, _ = newTestBuilder(&fakeDocker{}, &fakeController{})
+	if err := r.Run(NewBuild("alice", "go-app.git", sha, gitHome)); err == nil {
+		t.Fatal("expected the build to fail without a config")
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "../evil", Mode: 0644, Size: 4})
+	tw.Write([]byte("evil"))
+	tw.Close()
+	if err := extract(&buf, dir); err == nil {
+		t.Error("expected a path outside of the directory to be rejected")
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	gitHome, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitHome)
+	sha := newRepo(t, gitHome, "go-app.git", map[string]string{"main.go": "package main\n"})
+
+	docker := &fakeDocker{}
+	controller := &fakeController{config: &builder.Config{}}
+	r, _ := newTestBuilder(docker, controller)
+	cancel := make(chan struct{})
+	close(cancel)
+	r.Cancel = cancel
+	b := NewBuild("alice", "go-app.git", sha, gitHome)
+	if err := r.Run(b); err != ErrCancelled {
+		t.Fatalf("expected the build to be cancelled, got %v", err)
+	}
+	if len(docker.runs) != 0 || len(controller.hooks) != 0 {
+		t.Errorf("expected nothing to run after the build was cancelled")
+	}
+	if _, err := os.Stat(b.Dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed", b.Dir)
+	}
+}
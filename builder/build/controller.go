@@ -0,0 +1,109 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/deis/deis/builder"
+)
+
+const (
+	contentType string = "application/json"
+	userAgent   string = "deis-builder"
+)
+
+// shellshock matches an environment variable trying to exploit Shellshock.
+var shellshock = regexp.MustCompile(`\(\)\s+\{[^\}]+\};\s+(.*)`)
+
+// Controller is the part of the controller's API a build uses.
+type Controller interface {
+	// Config returns the configuration of an application.
+	Config(user, app string) (*builder.Config, error)
+	// Publish creates a release of a build and deploys it.
+	Publish(hook *builder.BuildHook) (*builder.BuildHookResponse, error)
+}
+
+// HTTPController is a Controller talking to the controller's builder hooks.
+type HTTPController struct {
+	// URL is the base URL of the controller, e.g. http://10.0.0.1:8000.
+	URL string
+	// Key is the builder key shared with the controller.
+	Key    string
+	Client *http.Client
+}
+
+// NewHTTPController returns a Controller for the controller at url.
+func NewHTTPController(url, key string) *HTTPController {
+	return &HTTPController{
+		URL:    strings.TrimSuffix(url, "/"),
+		Key:    key,
+		Client: &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Config implements Controller.Config.
+func (c *HTTPController) Config(user, app string) (*builder.Config, error) {
+	body, err := c.post("/v1/hooks/config", &builder.ConfigHook{ReceiveUser: user, ReceiveRepo: app})
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving config from controller: %v", err)
+	}
+	config, err := builder.ParseConfig(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing config from controller: %v", err)
+	}
+	return config, nil
+}
+
+// Publish implements Controller.Publish.
+func (c *HTTPController) Publish(hook *builder.BuildHook) (*builder.BuildHookResponse, error) {
+	body, err := c.post("/v1/hooks/build", hook)
+	if err != nil {
+		return nil, err
+	}
+	var resp builder.BuildHookResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid controller json response: %s", body)
+	}
+	return &resp, nil
+}
+
+func (c *HTTPController) post(path string, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if shellshock.Match(data) {
+		return nil, fmt.Errorf("an environment variable in the app is trying to exploit Shellshock")
+	}
+	req, err := http.NewRequest("POST", c.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Accept", contentType)
+	req.Header.Add("User-Agent", userAgent)
+	req.Header.Add("X-Deis-Builder-Auth", c.Key)
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusServiceUnavailable:
+		return nil, fmt.Errorf("check the controller. is it running?")
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
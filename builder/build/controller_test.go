@@ -0,0 +1,89 @@
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deis/deis/builder"
+)
+
+func TestHTTPControllerConfig(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/hooks/config" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if key := r.Header.Get("X-Deis-Builder-Auth"); key != "secret" {
+			t.Errorf("expected builder key secret, got %s", key)
+		}
+		var hook builder.ConfigHook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			t.Fatal(err)
+		}
+		if hook.ReceiveUser != "alice" || hook.ReceiveRepo != "go-app" {
+			t.Errorf("unexpected config hook %v", hook)
+		}
+		w.Write([]byte(`{"owner": "alice", "app": "go-app", "values": {"FOO": "bar"}}`))
+	}))
+	defer ts.Close()
+
+	config, err := NewHTTPController(ts.URL+"/", "secret").Config("alice", "go-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Values["FOO"] != "bar" {
+		t.Errorf("expected FOO=bar, got %v", config.Values)
+	}
+}
+
+func TestHTTPControllerPublish(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/hooks/build" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), `"procfile":{"web":"bin/web"}`) {
+			t.Errorf("unexpected build hook %s", body)
+		}
+		w.Write([]byte(`{"release": {"version": 3}, "domains": ["go-app.example.com"]}`))
+	}))
+	defer ts.Close()
+
+	resp, err := NewHTTPController(ts.URL, "secret").Publish(&builder.BuildHook{
+		Sha:      "abc",
+		Procfile: builder.ProcessType{"web": "bin/web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Release["version"] != 3 || resp.Domains[0] != "go-app.example.com" {
+		t.Errorf("unexpected response %v", resp)
+	}
+}
+
+func TestHTTPControllerErrors(t *testing.T) {
+	status := http.StatusNotFound
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte("nope\n"))
+	}))
+	defer ts.Close()
+	c := NewHTTPController(ts.URL, "secret")
+
+	_, err := c.Publish(&builder.BuildHook{})
+	if err == nil || err.Error() != "check the controller. is it running?" {
+		t.Errorf("unexpected error for 404: %v", err)
+	}
+	status = http.StatusForbidden
+	_, err = c.Publish(&builder.BuildHook{})
+	if err == nil || err.Error() != "403 Forbidden: nope" {
+		t.Errorf("unexpected error for 403: %v", err)
+	}
+	_, err = c.Publish(&builder.BuildHook{Procfile: builder.ProcessType{"web": "() { :;}; echo vulnerable"}})
+	if err == nil || !strings.Contains(err.Error(), "Shellshock") {
+		t.Errorf("expected a Shellshock payload to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+)
+
+// RunOptions configures a container started by Docker.Run.
+type RunOptions struct {
+	Image string
+	// Env holds KEY=value pairs.
+	Env []string
+	// Volumes holds host:container[:mode] bind mounts.
+	Volumes []string
+}
+
+// Docker is the part of Docker a build uses.
+type Docker interface {
+	// Run starts a detached container and returns its ID.
+	Run(opts RunOptions) (string, error)
+	// Attach streams the output of a container to out until it exits. It returns an error if
+	// the container exited with a non-zero status.
+	Attach(id string, out io.Writer) error
+	// CopyFrom copies a file out of a container into dir.
+	CopyFrom(id, path, dir string) error
+	// Build builds the Dockerfile in dir as the image tag, with buildArgs holding KEY=value
+	// build args, streaming the output to out.
+	Build(dir, tag string, buildArgs []string, out io.Writer) error
+	// Push pushes an image to its registry.
+	Push(tag string) error
+	// Remove forcibly removes a container.
+	Remove(id string) error
+}
+
+// CLI is a Docker which runs the docker client, so builds use the same daemon settings as a
+// docker command run in the builder.
+type CLI struct {
+	// Path is the path to the docker client.
+	Path string
+	// Cancel kills the running docker commands when it is closed.
+	Cancel <-chan struct{}
+}
+
+// NewCLI returns a Docker using the docker client found in $PATH.
+func NewCLI() *CLI {
+	return &CLI{Path: "docker"}
+}
+
+// Run implements Docker.Run.
+func (d *CLI) Run(opts RunOptions) (string, error) {
+	args := []string{"run", "-d"}
+	for _, e := range opts.Env {
+		args = append(args, "-e", e)
+	}
+	for _, v := range opts.Volumes {
+		args = append(args, "-v", v)
+	}
+	args = append(args, opts.Image)
+	out, err := d.output(args...)
+	return strings.TrimSpace(out), err
+}
+
+// Attach implements Docker.Attach.
+func (d *CLI) Attach(id string, out io.Writer) error {
+	return d.stream(out, "attach", id)
+}
+
+// CopyFrom implements Docker.CopyFrom.
+func (d *CLI) CopyFrom(id, path, dir string) error {
+	_, err := d.output("cp", id+":"+path, dir)
+	return err
+}
+
+// Build implements Docker.Build.
+func (d *CLI) Build(dir, tag string, buildArgs []string, out io.Writer) error {
+	args := []string{"build", "-t", tag}
+	for _, arg := range buildArgs {
+		args = append(args, "--build-arg", arg)
+	}
+	args = append(args, dir)
+	return d.stream(out, args...)
+}
+
+// Push implements Docker.Push.
+func (d *CLI) Push(tag string) error {
+	_, err := d.output("push", tag)
+	return err
+}
+
+// Remove implements Docker.Remove. It is not cancelled, as cancelled builds still remove their
+// containers.
+func (d *CLI) Remove(id string) error {
+	out, err := exec.Command(d.Path, "rm", "-f", id).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker rm: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// output runs a docker command, returning its output. The output is included in the error if
+// the command fails.
+func (d *CLI) output(args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.Command(d.Path, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := d.run(cmd); err != nil {
+		return "", fmt.Errorf("docker %s: %v: %s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
+
+// stream runs a docker command, sending its output to out.
+func (d *CLI) stream(out io.Writer, args ...string) error {
+	if out == nil {
+		out = ioutil.Discard
+	}
+	cmd := exec.Command(d.Path, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := d.run(cmd); err != nil {
+		return fmt.Errorf("docker %s: %v", args[0], err)
+	}
+	return nil
+}
+
+// run runs a docker command until it exits or the CLI is cancelled.
+func (d *CLI) run(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		return err
+	case <-d.Cancel:
+		cmd.Process.Kill()
+		<-exited
+		return ErrCancelled
+	}
+}
@@ -0,0 +1,83 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeCLI returns a CLI running script instead of docker.
+func fakeCLI(t *testing.T, script string) (*CLI, func()) {
+	dir, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "docker")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &CLI{Path: path}, func() { os.RemoveAll(dir) }
+}
+
+func TestCLI(t *testing.T) {
+	d, cleanup := fakeCLI(t, `echo "$@"`)
+	defer cleanup()
+
+	id, err := d.Run(RunOptions{
+		Image:   slugbuilderImage,
+		Env:     []string{"SOURCE_VERSION=abc"},
+		Volumes: []string{"/tmp/app:/tmp/app"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "run -d -e SOURCE_VERSION=abc -v /tmp/app:/tmp/app deis/slugbuilder"; id != expected {
+		t.Errorf("expected %q, got %q", expected, id)
+	}
+
+	var out bytes.Buffer
+	// build args are passed as they are, without going through a shell
+	buildArgs := []string{`GREETING=hello "world" $HOME`}
+	if err := d.Build("/tmp/app", "registry/go-app:git-abc", buildArgs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "build -t registry/go-app:git-abc --build-arg GREETING=hello \"world\" $HOME /tmp/app\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestCLIError(t *testing.T) {
+	d, cleanup := fakeCLI(t, "echo 'no such image' >&2; exit 1")
+	defer cleanup()
+
+	err := d.Push("registry/go-app:git-abc")
+	if err == nil || err.Error() != "docker push: exit status 1: no such image" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestCLICancel(t *testing.T) {
+	d, cleanup := fakeCLI(t, `[ "$1" = rm ] || exec sleep 10`)
+	defer cleanup()
+	cancel := make(chan struct{})
+	d.Cancel = cancel
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(cancel)
+	}()
+	start := time.Now()
+	if err := d.Attach("container1", nil); err == nil || err.Error() != "docker attach: build cancelled" {
+		t.Errorf("expected the command to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the command to be killed promptly, took %s", elapsed)
+	}
+	// containers of cancelled builds are still removed
+	if err := d.Remove("container1"); err != nil {
+		t.Errorf("expected the container to be removed, got %v", err)
+	}
+}
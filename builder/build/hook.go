@@ -0,0 +1,82 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Hook builds the commit pushed to a repository. It is called by the git pre-receive hook with
+// the user, repository, sha and ref of the push, and returns the exit code of the hook. Only
+// pushes to the application's deployed branch are built, see CheckRef. Hooks written before
+// the ref was passed leave it out, and always build.
+//
+// The controller and registry are read from the environment: DEIS_CONTROLLER_URL,
+// DEIS_BUILDER_KEY and DEIS_REGISTRY. Repositories are found in GITHOME.
+//
+// The output of the build is kept in the repository's logs directory, and shipped to the logger
+// at DEIS_LOGGER over DEIS_LOGGER_PROTOCOL if it is set.
+//
+// The build is cancelled when the hook is interrupted or terminated, which the builder does
+// when the build times out or the client disconnects. Its container is still removed.
+func Hook(args []string) int {
+	if len(args) != 3 && len(args) != 4 {
+		fmt.Fprintln(os.Stderr, "Usage: builder build <user> <repo> <sha> [<ref>]")
+		return 1
+	}
+	gitHome := os.Getenv("GITHOME")
+	if gitHome == "" {
+		gitHome = "/home/git"
+	}
+
+	cancel := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	// the client may be gone, but the build must still clean up after itself
+	signal.Ignore(syscall.SIGPIPE)
+	go func() {
+		<-sigs
+		close(cancel)
+	}()
+
+	b := NewBuild(args[0], args[1], args[2], gitHome)
+	if len(args) == 4 {
+		b.Ref = args[3]
+	}
+	docker := NewCLI()
+	docker.Cancel = cancel
+	r := &Builder{
+		Controller: NewHTTPController(os.Getenv("DEIS_CONTROLLER_URL"), os.Getenv("DEIS_BUILDER_KEY")),
+		Docker:     docker,
+		Registry:   os.Getenv("DEIS_REGISTRY"),
+		SlugGroup:  SlugGroup,
+		Out:        os.Stdout,
+		Cancel:     cancel,
+	}
+	// refs which are not deployed get no build, nor build log
+	if build, err := r.CheckRef(b); err != nil {
+		fmt.Fprintf(os.Stdout, " !     %v\n", err)
+		return 1
+	} else if !build {
+		return 0
+	}
+
+	out := io.Writer(os.Stdout)
+	buildLog, err := OpenLog(b, os.Getenv("DEIS_LOGGER"), os.Getenv("DEIS_LOGGER_PROTOCOL"))
+	if err != nil {
+		fmt.Fprintf(os.Stdout, " !     not keeping the build log: %v\n", err)
+	} else {
+		defer buildLog.Close()
+		out = io.MultiWriter(buildLog, clientWriter{os.Stdout})
+	}
+	r.Out = out
+	r.step("Build " + b.ID)
+	if err := r.Run(b); err != nil {
+		fmt.Fprintf(out, " !     %v\n", err)
+		fmt.Fprintf(out, " !     See the build log with `deis builds:logs %s -a %s`\n", b.ID, b.App)
+		return 1
+	}
+	return 0
+}
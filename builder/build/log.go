@@ -0,0 +1,137 @@
+package build
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	dtime "github.com/deis/deis/pkg/time"
+)
+
+// maxMessageSize is the largest log line shipped in a single UDP packet.
+const maxMessageSize int = 65507
+
+// Log keeps the output of a build in a file under the application's repository, and ships it
+// line by line to the logger. Writes to a Log never fail, so that a build is not interrupted
+// when its log cannot be kept.
+type Log struct {
+	// Path is the file the log is kept in.
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+	// conn is the logger, if any.
+	conn     net.Conn
+	protocol string
+	tag      string
+	// line holds the output written since the last newline.
+	line []byte
+}
+
+// clientWriter writes the output of a build to the client, ignoring errors, so that writing it
+// alongside the build log with io.MultiWriter does not stop the log once the client has gone.
+type clientWriter struct {
+	w io.Writer
+}
+
+func (c clientWriter) Write(p []byte) (int, error) {
+	c.w.Write(p)
+	return len(p), nil
+}
+
+// OpenLog creates the log of b. If addr is set, every line is also shipped to the logger at
+// addr, using protocol ("udp" or "tcp"), as a message of the builder process of the
+// application.
+func OpenLog(b *Build, addr, protocol string) (*Log, error) {
+	dir := filepath.Join(b.RepoDir, "logs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &Log{
+		Path:     filepath.Join(dir, b.ID+".log"),
+		protocol: strings.ToLower(protocol),
+		tag:      fmt.Sprintf("%s[builder.%s]", b.App, b.ID),
+	}
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+
+	if addr != "" {
+		if l.protocol == "" {
+			l.protocol = "udp"
+		}
+		// the build goes on without shipping its log if the logger is not reachable
+		if conn, err := net.DialTimeout(l.protocol, addr, 5*time.Second); err == nil {
+			l.conn = conn
+		} else {
+			fmt.Fprintf(l.file, "not shipping this log to the logger: %v\n", err)
+		}
+	}
+	return l, nil
+}
+
+// Write implements io.Writer.
+func (l *Log) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file.Write(p)
+	if l.conn == nil {
+		return len(p), nil
+	}
+	l.line = append(l.line, p...)
+	for {
+		i := bytes.IndexByte(l.line, '\n')
+		if i < 0 {
+			break
+		}
+		l.ship(l.line[:i])
+		l.line = l.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// ship sends a line to the logger in the format of the application logs.
+func (l *Log) ship(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	msg := []byte(fmt.Sprintf("%s %s: %s", time.Now().Format(dtime.DeisDatetimeFormat), l.tag, line))
+	if l.protocol == "tcp" {
+		msg = append(msg, '\n')
+	} else if len(msg) > maxMessageSize {
+		msg = append(msg[:maxMessageSize-3], "..."...)
+	}
+	l.conn.Write(msg)
+}
+
+// Close ships the last line of the log and closes it.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		if len(l.line) > 0 {
+			l.ship(l.line)
+			l.line = nil
+		}
+		l.conn.Close()
+	}
+	return l.file.Close()
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	u := make([]byte, 16)
+	if _, err := rand.Read(u); err != nil {
+		panic(err)
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
@@ -0,0 +1,122 @@
+package build
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func newTestLogBuild(t *testing.T) *Build {
+	gitHome, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBuild("alice", "go-app.git", "d1cdc9b51e2e4bd4b2e1b5e87a2fb9be4d4ebd6d", gitHome)
+}
+
+func TestLog(t *testing.T) {
+	b := newTestLogBuild(t)
+	defer os.RemoveAll(filepath.Dir(b.RepoDir))
+	logger, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	l, err := OpenLog(b, logger.LocalAddr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Write([]byte("-----> Building Docker image\r\nStep 0 : FROM "))
+	l.Write([]byte("deis/slugrunner\n"))
+	l.Write([]byte("done"))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "-----> Building Docker image\r\nStep 0 : FROM deis/slugrunner\ndone"; string(data) != expected {
+		t.Errorf("expected the log to be kept as %q, got %q", expected, data)
+	}
+	if l.Path != b.RepoDir+"/logs/"+b.ID+".log" {
+		t.Errorf("unexpected log path %s", l.Path)
+	}
+
+	buf := make([]byte, 1024)
+	for _, line := range []string{"-----> Building Docker image", "Step 0 : FROM deis/slugrunner", "done"} {
+		logger.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := logger.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := regexp.MustCompile(`^\S+ go-app\[builder\.` + b.ID + `\]: ` + regexp.QuoteMeta(line) + `$`)
+		if !msg.Match(buf[:n]) {
+			t.Errorf("expected %q to be shipped, got %q", line, buf[:n])
+		}
+	}
+}
+
+func TestLogWithoutLogger(t *testing.T) {
+	b := newTestLogBuild(t)
+	defer os.RemoveAll(filepath.Dir(b.RepoDir))
+	// nothing listens on the port of a closed listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	for _, addr := range []string{"", listener.Addr().String()} {
+		l, err := OpenLog(b, addr, "tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := l.Write([]byte("hello\n")); n != 6 || err != nil {
+			t.Errorf("expected writes to succeed, got %d, %v", n, err)
+		}
+		if err := l.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestLogAfterClientDisconnects(t *testing.T) {
+	b := newTestLogBuild(t)
+	defer os.RemoveAll(filepath.Dir(b.RepoDir))
+	l, err := OpenLog(b, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// writes to a pipe fail with EPIPE once its reader has gone, like those to a client
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r.Close()
+
+	out := io.MultiWriter(l, clientWriter{w})
+	for _, line := range []string{"-----> Building Docker image\n", "-----> Build complete.\n"} {
+		if n, err := io.WriteString(out, line); n != len(line) || err != nil {
+			t.Errorf("expected writes to succeed, got %d, %v", n, err)
+		}
+	}
+	data, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "-----> Building Docker image\n-----> Build complete.\n"; string(data) != expected {
+		t.Errorf("expected the log to be %q, got %q", expected, data)
+	}
+}
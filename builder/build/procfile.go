@@ -0,0 +1,96 @@
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/deis/deis/builder"
+)
+
+// readProcfile returns the process types of the source in dir: its Procfile if it has one, or
+// else the Procfile or default process types written by the buildpack to slug.tgz.
+func readProcfile(dir string) (builder.ProcessType, error) {
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "Procfile")); err == nil {
+		return parseProcfile(data)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, slugFile))
+	if os.IsNotExist(err) {
+		return builder.ProcessType{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, err := readSlug(f, "Procfile", ".release")
+	if err != nil {
+		return nil, err
+	}
+	// buildpacks may write a Procfile instead of declaring default process types in .release
+	if data, ok := files["Procfile"]; ok {
+		return parseProcfile(data)
+	}
+	if data, ok := files[".release"]; ok {
+		return parseRelease(data)
+	}
+	return builder.ProcessType{}, nil
+}
+
+func parseProcfile(data []byte) (builder.ProcessType, error) {
+	procfile := builder.ProcessType{}
+	err := yaml.Unmarshal(data, &procfile)
+	return procfile, err
+}
+
+// parseRelease returns the default process types of a buildpack's bin/release output.
+func parseRelease(data []byte) (builder.ProcessType, error) {
+	var release struct {
+		DefaultProcessTypes builder.ProcessType `yaml:"default_process_types"`
+	}
+	if err := yaml.Unmarshal(data, &release); err != nil {
+		return nil, err
+	}
+	if release.DefaultProcessTypes == nil {
+		return builder.ProcessType{}, nil
+	}
+	return release.DefaultProcessTypes, nil
+}
+
+// readSlug returns the contents of the named files at the root of a gzipped slug.
+func readSlug(r io.Reader, names ...string) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if !wanted[name] || !hdr.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		if files[name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deis/deis/builder"
+)
+
+func TestReadProcfile(t *testing.T) {
+	tests := []struct {
+		procfile string
+		slug     map[string]string
+		expected builder.ProcessType
+	}{
+		{"", nil, builder.ProcessType{}},
+		{"web: bin/web\n", nil, builder.ProcessType{"web": "bin/web"}},
+		// the source's Procfile wins over the buildpack's
+		{"web: bin/web\n", map[string]string{"Procfile": "web: bin/slug\n"}, builder.ProcessType{"web": "bin/web"}},
+		{"", map[string]string{"Procfile": "web: bin/slug\n"}, builder.ProcessType{"web": "bin/slug"}},
+		{"", map[string]string{
+			"Procfile": "worker: bin/worker\n",
+			".release": "default_process_types:\n  web: bin/release\n",
+		}, builder.ProcessType{"worker": "bin/worker"}},
+		{"", map[string]string{".release": "default_process_types:\n  web: bin/release\n"}, builder.ProcessType{"web": "bin/release"}},
+		{"", map[string]string{".release": "config_vars:\n  PATH: /app/bin\n"}, builder.ProcessType{}},
+		{"", map[string]string{"README": "hello"}, builder.ProcessType{}},
+	}
+
+	for i, test := range tests {
+		dir, err := ioutil.TempDir("", "deis-build")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if test.procfile != "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte(test.procfile), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.slug != nil {
+			f, err := os.Create(filepath.Join(dir, slugFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeSlug(f, test.slug); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+		}
+
+		procfile, err := readProcfile(dir)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if fmt.Sprint(procfile) != fmt.Sprint(test.expected) {
+			t.Errorf("%d: expected %v, got %v", i, test.expected, procfile)
+		}
+	}
+}
+
+func TestReadProcfileInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deis-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte("- web\n- worker\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readProcfile(dir); err == nil {
+		t.Error("expected a list to be an invalid Procfile")
+	}
+}
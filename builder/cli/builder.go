@@ -5,9 +5,14 @@ import (
 	"runtime"
 
 	"github.com/deis/deis/builder"
+	"github.com/deis/deis/builder/build"
 )
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		// called by the git pre-receive hook, see rootfs/etc/confd/templates/builder
+		os.Exit(build.Hook(os.Args[2:]))
+	}
 	os.Exit(builder.Run("boot"))
 }
@@ -3,12 +3,15 @@
 package etcd
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,6 +19,7 @@ import (
 	"github.com/Masterminds/cookoo/log"
 	"github.com/Masterminds/cookoo/safely"
 	"github.com/coreos/go-etcd/etcd"
+	"golang.org/x/crypto/ssh"
 )
 
 var (
@@ -49,6 +53,13 @@ type Setter interface {
 	Set(string, string, uint64) (*etcd.Response, error)
 }
 
+// Canceller cancels the running build of an application.
+//
+// Usually you will want to use *git.Builds to satisfy this.
+type Canceller interface {
+	Cancel(app, reason string) bool
+}
+
 // GetterSetter performs get and set operations.
 type GetterSetter interface {
 	Getter
@@ -58,12 +69,13 @@ type GetterSetter interface {
 // CreateClient creates a new Etcd client and prepares it for work.
 //
 // Params:
-// 	- url (string): A server to connect to.
-// 	- retries (int): Number of times to retry a connection to the server
-// 	- retrySleep (time.Duration): How long to sleep between retries
+//   - url (string): A server to connect to.
+//   - retries (int): Number of times to retry a connection to the server
+//   - retrySleep (time.Duration): How long to sleep between retries
 //
 // Returns:
-// 	This puts an *etcd.Client into the context.
+//
+//	This puts an *etcd.Client into the context.
 func CreateClient(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	url := p.Get("url", "http://localhost:4001").(string)
 
@@ -89,12 +101,12 @@ func CreateClient(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Inter
 // Get performs an etcd Get operation.
 //
 // Params:
-// 	- client (EtcdGetter): Etcd client
-// 	- path (string): The path/key to fetch
+//   - client (EtcdGetter): Etcd client
+//   - path (string): The path/key to fetch
 //
 // Returns:
-// - This puts an `etcd.Response` into the context, and returns an error
-//   if the client could not connect.
+//   - This puts an `etcd.Response` into the context, and returns an error
+//     if the client could not connect.
 func Get(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	cli, ok := p.Has("client")
 	if !ok {
@@ -114,16 +126,69 @@ func Get(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	return res, nil
 }
 
+// GetDuration gets a number of seconds from etcd.
+//
+// Params:
+//   - client (EtcdGetter): Etcd client
+//   - path (string): The key to fetch
+//   - default (time.Duration): Returned if the key is not set or is not a number.
+//
+// Returns:
+//   - time.Duration
+func GetDuration(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	client := p.Get("client", nil).(Getter)
+	path := p.Get("path", "").(string)
+	def := p.Get("default", time.Duration(0)).(time.Duration)
+
+	res, err := client.Get(path, false, false)
+	if err != nil || res.Node == nil {
+		return def, nil
+	}
+	secs, err := strconv.ParseUint(res.Node.Value, 10, 64)
+	if err != nil {
+		log.Warnf(c, "Expected %s to be a number of seconds, got '%s'", path, res.Node.Value)
+		return def, nil
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// GetInt gets a number from etcd.
+//
+// Params:
+//   - client (EtcdGetter): Etcd client
+//   - path (string): The key to fetch
+//   - default (int): Returned if the key is not set or is not a number.
+//
+// Returns:
+//   - int
+func GetInt(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	client := p.Get("client", nil).(Getter)
+	path := p.Get("path", "").(string)
+	def := p.Get("default", 0).(int)
+
+	res, err := client.Get(path, false, false)
+	if err != nil || res.Node == nil {
+		return def, nil
+	}
+	n, err := strconv.Atoi(res.Node.Value)
+	if err != nil || n < 0 {
+		log.Warnf(c, "Expected %s to be a number, got '%s'", path, res.Node.Value)
+		return def, nil
+	}
+	return n, nil
+}
+
 // IsRunning checks to see if etcd is running.
 //
 // It will test `count` times before giving up.
 //
 // Params:
-// 	- client (EtcdGetter)
-// 	- count (int): Number of times to try before giving up.
+//   - client (EtcdGetter)
+//   - count (int): Number of times to try before giving up.
 //
 // Returns:
-// 	boolean true if etcd is listening.
+//
+//	boolean true if etcd is listening.
 func IsRunning(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	client := p.Get("client", nil).(Getter)
 	count := p.Get("count", 20).(int)
@@ -142,13 +207,13 @@ func IsRunning(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrup
 // Set sets a value in etcd.
 //
 // Params:
-// 	- key (string): The key
-// 	- value (string): The value
-// 	- ttl (uint64): Time to live
-// 	- client (EtcdGetter): Client, usually an *etcd.Client.
+//   - key (string): The key
+//   - value (string): The value
+//   - ttl (uint64): Time to live
+//   - client (EtcdGetter): Client, usually an *etcd.Client.
 //
 // Returns:
-// 	- *etcd.Result
+//   - *etcd.Result
 func Set(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	key := p.Get("key", "").(string)
 	value := p.Get("value", "").(string)
@@ -169,15 +234,30 @@ func Set(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 // Some parts of the system require that we know not only the SSH key, but also
 // the name of the user. That information is stored in etcd.
 //
+// A key is found by the SHA256 fingerprint of the stored public key. Keys are stored under
+// their MD5 fingerprint, which is still matched for clients which only know that one.
+//
+// Users who authenticated with a certificate are named by its principal instead.
+//
 // Params:
-// 	- client (EtcdGetter)
-// 	- fingerprint (string): The fingerprint of the SSH key.
+//   - client (EtcdGetter)
+//   - fingerprint (string): The MD5 fingerprint of the SSH key.
+//   - sha256Fingerprint (string): The SHA256 fingerprint of the SSH key, if known.
+//   - permissions (*ssh.Permissions): The permissions granted by sshd.AuthKey, if any.
 //
 // Returns:
 // - username (string)
 func FindSSHUser(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	client := p.Get("client", nil).(Getter)
 	fingerprint := p.Get("fingerprint", nil).(string)
+	sha256Fingerprint := p.Get("sha256Fingerprint", "").(string)
+
+	if perms, ok := p.Get("permissions", nil).(*ssh.Permissions); ok && perms != nil {
+		if principal := perms.Extensions["principal"]; principal != "" {
+			log.Infof(c, "Found user %s in the certificate", principal)
+			return principal, nil
+		}
+	}
 
 	res, err := client.Get("/deis/builder/users", false, true)
 	if err != nil {
@@ -190,7 +270,7 @@ func FindSSHUser(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interr
 	for _, user := range res.Node.Nodes {
 		log.Infof(c, "Checking user %s", user.Key)
 		for _, keyprint := range user.Nodes {
-			if strings.HasSuffix(keyprint.Key, fingerprint) {
+			if strings.HasSuffix(keyprint.Key, fingerprint) || matchSHA256(keyprint.Value, sha256Fingerprint) {
 				parts := strings.Split(user.Key, "/")
 				username := parts[len(parts)-1]
 				log.Infof(c, "Found user %s for fingerprint %s", username, fingerprint)
@@ -199,21 +279,39 @@ func FindSSHUser(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interr
 		}
 	}
 
+	if sha256Fingerprint != "" {
+		fingerprint = sha256Fingerprint
+	}
 	return "", fmt.Errorf("User not found for fingerprint %s", fingerprint)
 }
 
+// matchSHA256 returns whether a public key in authorized_keys format has the SHA256
+// fingerprint.
+func matchSHA256(public, fingerprint string) bool {
+	if fingerprint == "" {
+		return false
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(public))
+	if err != nil {
+		return false
+	}
+	// the format of sshd.FingerprintSHA256, without making etcd depend on sshd
+	hash := sha256.Sum256(key.Marshal())
+	return "SHA256:"+strings.TrimRight(base64.StdEncoding.EncodeToString(hash[:]), "=") == fingerprint
+}
+
 // StoreHostKeys stores SSH hostkeys locally.
 //
 // First it tries to fetch them from etcd. If the keys are not present there,
 // it generates new ones and then puts them into etcd.
 //
 // Params:
-// 	- client(EtcdGetterSetter)
-// 	- ciphers([]string): A list of ciphers to generate. Defaults are dsa,
-// 		ecdsa, ed25519 and rsa.
-// 	- basepath (string): Base path in etcd (ETCD_PATH).
-// Returns:
+//   - client(EtcdGetterSetter)
+//   - ciphers([]string): A list of ciphers to generate. Defaults are dsa,
+//     ecdsa, ed25519 and rsa.
+//   - basepath (string): Base path in etcd (ETCD_PATH).
 //
+// Returns:
 func StoreHostKeys(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	defaultCiphers := []string{"rsa", "dsa", "ecdsa", "ed25519"}
 	client := p.Get("client", nil).(GetterSetter)
@@ -330,11 +428,11 @@ func genSSHKeys(c cookoo.Context) error {
 // This will notify etcd as long as the local sshd is running.
 //
 // Params:
-// 	- base (string): The base path to write the data: $base/host and $base/port.
-// 	- host (string): The hostname
-// 	- port (string): The port
-// 	- client (Setter): The client to use to write the data to etcd.
-// 	- sshPid (int): The PID for SSHD. If SSHD dies, this stops notifying.
+//   - base (string): The base path to write the data: $base/host and $base/port.
+//   - host (string): The hostname
+//   - port (string): The port
+//   - client (Setter): The client to use to write the data to etcd.
+//   - sshPid (int): The PID for SSHD. If SSHD dies, this stops notifying.
 func UpdateHostPort(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	base := p.Get("base", "").(string)
 	host := p.Get("host", "").(string)
@@ -388,11 +486,13 @@ func setHostPort(client Setter, base, host, port string, ttl uint64) error {
 // MakeDir makes a directory in Etcd.
 //
 // Params:
-// 	- client (EtcdDirCreator): Etcd client
-//  - path (string): The name of the directory to create.
-// 	- ttl (uint64): Time to live.
+//   - client (EtcdDirCreator): Etcd client
+//   - path (string): The name of the directory to create.
+//   - ttl (uint64): Time to live.
+//
 // Returns:
-// 	*etcd.Response
+//
+//	*etcd.Response
 func MakeDir(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	name := p.Get("path", "").(string)
 	ttl := p.Get("ttl", uint64(0)).(uint64)
@@ -414,103 +514,56 @@ func MakeDir(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 	return res, nil
 }
 
-// Watch watches a given path, and executes a git check-repos for each event.
+// WatchCancel watches for builds cancelled through the controller, and cancels them.
 //
-// It starts the watcher and then returns. The watcher runs on its own
-// goroutine. To stop the watching, send the returned channel a bool.
+// The controller cancels the build of an application by setting $path/$app to the name of
+// the user cancelling it. Keys which are already set when the watch starts are ignored.
 //
 // Params:
-// - client (Watcher): An Etcd client.
-// - path (string): The path to watch
-//
-// Returns:
-// 	- chan bool: Send this a message to stop the watcher.
-func Watch(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
-	// etcdctl -C $ETCD watch --recursive /deis/services
-	path := p.Get("path", "/deis/services").(string)
-	cli, ok := p.Has("client")
-	if !ok {
-		return nil, errors.New("No etcd client found.")
-	}
-	client := cli.(Watcher)
+//   - client (Watcher): An Etcd client.
+//   - path (string): The path to watch
+//   - builds (Canceller): The running builds.
+func WatchCancel(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	path := p.Get("path", "/deis/builder/cancel").(string)
+	client := p.Get("client", nil).(Watcher)
+	builds := p.Get("builds", nil).(Canceller)
 
-	// Stupid hack because etcd watch seems to be broken, constantly complaining
-	// that the JSON it received is malformed.
 	safely.GoDo(c, func() {
+		var index uint64
 		for {
-			response, err := client.Watch(path, 0, true, nil, nil)
+			res, err := client.Watch(path, index, true, nil, nil)
 			if err != nil {
 				log.Errf(c, "Etcd Watch failed: %s", err)
-				time.Sleep(50 * time.Millisecond)
+				// start over from the current index, which may have been cleared
+				index = 0
+				time.Sleep(time.Second)
 				continue
 			}
-
-			if response.Node == nil {
-				log.Infof(c, "Unexpected Etcd message: %v", response)
-			}
-			git := exec.Command("/home/git/check-repos")
-			if out, err := git.CombinedOutput(); err != nil {
-				log.Errf(c, "Failed git check-repos: %s", err)
-				log.Infof(c, "Output: %s", out)
+			if res.Node == nil {
+				continue
 			}
+			index = res.Node.ModifiedIndex + 1
+			cancelBuild(c, builds, path, res)
 		}
-
 	})
-
 	return nil, nil
+}
 
-	/* Watch seems to be broken. So we do this stupid watch loop instead.
-	receiver := make(chan *etcd.Response)
-	stop := make(chan bool)
-	// Buffer the channels so that we don't hang waiting for go-etcd to
-	// read off the channel.
-	stopetcd := make(chan bool, 1)
-	stopwatch := make(chan bool, 1)
-
-
-	// Watch for errors.
-	safely.GoDo(c, func() {
-		// When a receiver is passed in, no *Response is ever returned. Instead,
-		// Watch acts like an error channel, and receiver gets all of the messages.
-		_, err := client.Watch(path, 0, true, receiver, stopetcd)
-		if err != nil {
-			log.Infof(c, "Watcher stopped with error '%s'", err)
-			stopwatch <- true
-			//close(stopwatch)
-		}
-	})
-	// Watch for events
-	safely.GoDo(c, func() {
-		for {
-			select {
-			case msg := <-receiver:
-				if msg.Node != nil {
-					log.Infof(c, "Received notification %s for %s", msg.Action, msg.Node.Key)
-				} else {
-					log.Infof(c, "Received unexpected etcd message: %v", msg)
-				}
-				git := exec.Command("/home/git/check-repos")
-				if out, err := git.CombinedOutput(); err != nil {
-					log.Errf(c, "Failed git check-repos: %s", err)
-					log.Infof(c, "Output: %s", out)
-				}
-			case <-stopwatch:
-				c.Logf("debug", "Received signal to stop watching events.")
-				return
-			}
-		}
-	})
-	// Fan out stop requests.
-	safely.GoDo(c, func() {
-		<-stop
-		stopwatch <- true
-		stopetcd <- true
-		close(stopwatch)
-		close(stopetcd)
-	})
-
-	return stop, nil
-	*/
+// cancelBuild cancels the build named by a cancellation event.
+func cancelBuild(c cookoo.Context, builds Canceller, path string, res *etcd.Response) {
+	if res.Action != "set" && res.Action != "create" {
+		return
+	}
+	app := strings.TrimPrefix(res.Node.Key, path+"/")
+	reason := "build cancelled"
+	if res.Node.Value != "" {
+		reason += " by " + res.Node.Value
+	}
+	if builds.Cancel(app, reason) {
+		log.Infof(c, "Cancelled the build of %s", app)
+	} else {
+		log.Infof(c, "Not cancelling %s: no build is running", app)
+	}
 }
 
 // checkRetry overrides etcd.DefaultCheckRetry.
@@ -1,10 +1,13 @@
 package etcd
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/Masterminds/cookoo"
 	"github.com/coreos/go-etcd/etcd"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestInterfaces(t *testing.T) {
@@ -90,6 +93,162 @@ func TestMakeDir(t *testing.T) {
 	}
 }
 
+func TestGetDuration(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"600":  10 * time.Minute,
+		"0":    0,
+		"ten":  time.Minute,
+		"-600": time.Minute,
+	} {
+		reg, router, cxt := cookoo.Cookoo()
+		reg.Route("test", "Test route").
+			Does(GetDuration, "res").
+			Using("client").WithDefault(&valueClient{value: value}).
+			Using("path").WithDefault("/deis/builder/buildTimeout").
+			Using("default").WithDefault(time.Minute)
+
+		if err := router.HandleRequest("test", cxt, true); err != nil {
+			t.Error(err)
+		}
+		if actual := cxt.Get("res", nil).(time.Duration); actual != expected {
+			t.Errorf("Expected %s for '%s', got %s", expected, value, actual)
+		}
+	}
+}
+
+func TestGetInt(t *testing.T) {
+	for value, expected := range map[string]int{
+		"8":   8,
+		"0":   0,
+		"two": 2,
+		"-1":  2,
+	} {
+		reg, router, cxt := cookoo.Cookoo()
+		reg.Route("test", "Test route").
+			Does(GetInt, "res").
+			Using("client").WithDefault(&valueClient{value: value}).
+			Using("path").WithDefault("/deis/builder/maxBuilds").
+			Using("default").WithDefault(2)
+
+		if err := router.HandleRequest("test", cxt, true); err != nil {
+			t.Error(err)
+		}
+		if actual := cxt.Get("res", nil).(int); actual != expected {
+			t.Errorf("Expected %d for '%s', got %d", expected, value, actual)
+		}
+	}
+}
+
+// usersClient stores the key of alice under its MD5 fingerprint, and the key of bob under
+// another name.
+type usersClient struct{}
+
+func (u *usersClient) Get(key string, sort, recurse bool) (*etcd.Response, error) {
+	const public = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCfQkkUUoxpvcNMkvv7jqnfodgs37M2eBO" +
+		"APgLK+KNBMaZaaKB4GF1QhTCMfFhoiTW3rqa0J75bHJcdkoobtTHlK8XUrFqsquWyg3XhsT" +
+		"Yr/3RQQXvO86e2sF7SVDJqVtpnbQGc5SgNrHCeHJmf5HTbXSIjCO/AJSvIjnituT/SIAMGe" +
+		"Bw0Nq/iSltwYAek1hiKO7wSmLcIQ8U4A00KEUtalaumf2aHOcfjgPfzlbZGP0S0cuBwSqLr" +
+		"8b5XGPmkASNdUiuJY4MJOce7bFU14B7oMAy2xacODUs1momUeYtGI9T7X2WMowJaO7tP3Gl" +
+		"sgBMP81VfYTfYChAyJpKp2yoP autotest@autotesting"
+	return &etcd.Response{Action: "get", Node: &etcd.Node{Key: key, Dir: true, Nodes: etcd.Nodes{
+		{Key: key + "/alice", Dir: true, Nodes: etcd.Nodes{
+			{Key: key + "/alice/aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99", Value: "ssh-rsa AAAA alice"},
+		}},
+		{Key: key + "/bob", Dir: true, Nodes: etcd.Nodes{
+			{Key: key + "/bob/laptop", Value: public},
+		}},
+	}}}, nil
+}
+
+func TestFindSSHUser(t *testing.T) {
+	tests := []struct {
+		fingerprint, sha256Fingerprint, expected string
+	}{
+		{"aa:bb:cc:dd:ee:ff:00:11:22:33:44:55:66:77:88:99", "SHA256:unknown", "alice"},
+		{"54:6d:da:1f:91:b5:2b:6f:a2:83:90:c4:f9:73:76:f5", "SHA256:dticnEeje35MjrAeZF1NFPuQ/Py/KX4h8GL0crzvktM", "bob"},
+		{"54:6d:da:1f:91:b5:2b:6f:a2:83:90:c4:f9:73:76:f5", "", ""},
+	}
+	for _, test := range tests {
+		reg, router, cxt := cookoo.Cookoo()
+		reg.Route("test", "Test route").
+			Does(FindSSHUser, "res").
+			Using("client").WithDefault(&usersClient{}).
+			Using("fingerprint").WithDefault(test.fingerprint).
+			Using("sha256Fingerprint").WithDefault(test.sha256Fingerprint)
+
+		err := router.HandleRequest("test", cxt, true)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("Expected no user for %s", test.fingerprint)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		if user := cxt.Get("res", "").(string); user != test.expected {
+			t.Errorf("Expected %s for %s, got '%s'", test.expected, test.fingerprint, user)
+		}
+	}
+}
+
+func TestFindSSHUserPrincipal(t *testing.T) {
+	reg, router, cxt := cookoo.Cookoo()
+	// etcd knows no users, but the certificate names one
+	reg.Route("test", "Test route").
+		Does(FindSSHUser, "res").
+		Using("client").WithDefault(&valueClient{}).
+		Using("fingerprint").WithDefault("78:b9:21:20:1a:ed:e6:10:05:35:47:da:d4:1f:b6:73").
+		Using("permissions").WithDefault(&ssh.Permissions{
+		Extensions: map[string]string{"user": "git", "principal": "alice"},
+	})
+
+	if err := router.HandleRequest("test", cxt, true); err != nil {
+		t.Fatal(err)
+	}
+	if user := cxt.Get("res", "").(string); user != "alice" {
+		t.Errorf("Expected the user to be alice, got '%s'", user)
+	}
+}
+
+func TestCancelBuild(t *testing.T) {
+	_, _, cxt := cookoo.Cookoo()
+	builds := &stubCanceller{running: map[string]bool{"go-app": true}}
+	path := "/deis/builder/cancel"
+
+	for _, res := range []*etcd.Response{
+		{Action: "set", Node: &etcd.Node{Key: path + "/go-app", Value: "alice"}},
+		{Action: "expire", Node: &etcd.Node{Key: path + "/ruby-app"}},
+		{Action: "create", Node: &etcd.Node{Key: path + "/ruby-app"}},
+	} {
+		cancelBuild(cxt, builds, path, res)
+	}
+
+	expected := []string{"go-app: build cancelled by alice", "ruby-app: build cancelled"}
+	if fmt.Sprint(builds.cancelled) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, builds.cancelled)
+	}
+}
+
+type stubCanceller struct {
+	running   map[string]bool
+	cancelled []string
+}
+
+func (s *stubCanceller) Cancel(app, reason string) bool {
+	s.cancelled = append(s.cancelled, app+": "+reason)
+	return s.running[app]
+}
+
+// valueClient is a Getter returning a single value.
+type valueClient struct {
+	value string
+}
+
+func (v *valueClient) Get(key string, sort, recurse bool) (*etcd.Response, error) {
+	return &etcd.Response{Action: "get", Node: &etcd.Node{Key: key, Value: v.value}}, nil
+}
+
 // stubClient implements EtcdGetter and EtcdDirCreator
 type stubClient struct {
 }
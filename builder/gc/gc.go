@@ -0,0 +1,350 @@
+// Package gc removes what the builder no longer needs.
+//
+// The repository and images of a deleted application are removed, and only the latest builds,
+// build logs and images of the other applications are kept.
+package gc
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/cookoo"
+	"github.com/Masterminds/cookoo/log"
+	"github.com/Masterminds/cookoo/safely"
+	goetcd "github.com/coreos/go-etcd/etcd"
+	docli "github.com/fsouza/go-dockerclient"
+
+	"github.com/deis/deis/builder/etcd"
+)
+
+// keyNotFound is the etcd error code of a missing key.
+const keyNotFound = 100
+
+// Policy says what the collector keeps.
+type Policy struct {
+	// Keep is how many builds, build logs and images of an application are kept. Zero keeps
+	// them all.
+	Keep int
+	// DryRun only logs what would be removed.
+	DryRun bool
+}
+
+// Images is the part of the Docker API the collector uses.
+//
+// Usually you will want to use go-dockerclient's *docker.Client to satisfy this.
+type Images interface {
+	ListImages(all bool) ([]docli.APIImages, error)
+	RemoveImage(name string) error
+}
+
+// Builds tells which applications are being built.
+//
+// Usually you will want to use *git.Builds to satisfy this.
+type Builds interface {
+	Building() map[string]bool
+}
+
+// Collector removes the repositories, builds, build logs and images the builder no longer
+// needs.
+type Collector struct {
+	// GitHome holds the repositories.
+	GitHome string
+	Images  Images
+	Builds  Builds
+	// Exists returns whether an application still exists.
+	Exists func(app string) (bool, error)
+}
+
+// Collect removes what policy does not keep. The applications being built are left alone, and
+// so is an application whose existence cannot be checked.
+func (g *Collector) Collect(c cookoo.Context, policy Policy) error {
+	start := time.Now()
+	repos, err := filepath.Glob(filepath.Join(g.GitHome, "*.git"))
+	if err != nil {
+		return err
+	}
+	images, err := g.appImages()
+	if err != nil {
+		return err
+	}
+	apps := make(map[string]bool)
+	for _, repo := range repos {
+		apps[strings.TrimSuffix(filepath.Base(repo), ".git")] = true
+	}
+	for app := range images {
+		apps[app] = true
+	}
+
+	building := g.Builds.Building()
+	for app := range apps {
+		if building[app] {
+			continue
+		}
+		exists, err := g.Exists(app)
+		if err != nil {
+			log.Warnf(c, "Not collecting %s: %s", app, err)
+			continue
+		}
+		repo := filepath.Join(g.GitHome, app+".git")
+		if !exists {
+			// the application is gone, and so is everything built for it
+			g.remove(c, policy, repo)
+			for _, image := range images[app] {
+				g.removeImage(c, policy, image)
+			}
+			continue
+		}
+		if policy.Keep == 0 {
+			continue
+		}
+		for _, dir := range []string{"build", "logs"} {
+			g.trim(c, policy, filepath.Join(repo, dir))
+		}
+		if len(images[app]) > policy.Keep {
+			for _, image := range images[app][policy.Keep:] {
+				g.removeImage(c, policy, image)
+			}
+		}
+	}
+
+	g.removeDangling(c, policy, start)
+	return nil
+}
+
+// appImages returns the names of the images built for each application, newest image first.
+// An image tagged more than once has all its names.
+func (g *Collector) appImages() (map[string][][]string, error) {
+	list, err := g.Images.ListImages(false)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byCreated(list))
+	images := make(map[string][][]string)
+	for _, image := range list {
+		names := make(map[string][]string)
+		var apps []string
+		for _, name := range image.RepoTags {
+			// builds are tagged REGISTRY/APP:git-SHA
+			i := strings.LastIndex(name, ":")
+			if i < 0 || !strings.HasPrefix(name[i+1:], "git-") {
+				continue
+			}
+			app := path.Base(name[:i])
+			if names[app] == nil {
+				apps = append(apps, app)
+			}
+			names[app] = append(names[app], name)
+		}
+		for _, app := range apps {
+			images[app] = append(images[app], names[app])
+		}
+	}
+	return images, nil
+}
+
+// trim removes all but the policy.Keep latest entries of dir.
+func (g *Collector) trim(c cookoo.Context, policy Policy, dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		log.Warnf(c, "Failed to read %s: %s", dir, err)
+		return
+	}
+	if len(entries) <= policy.Keep {
+		return
+	}
+	sort.Sort(byModTime(entries))
+	for _, entry := range entries[policy.Keep:] {
+		g.remove(c, policy, filepath.Join(dir, entry.Name()))
+	}
+}
+
+func (g *Collector) remove(c cookoo.Context, policy Policy, path string) {
+	if _, err := os.Lstat(path); err != nil {
+		return
+	}
+	if policy.DryRun {
+		log.Infof(c, "Would remove %s (dry run)", path)
+		return
+	}
+	log.Infof(c, "Removing %s", path)
+	if err := os.RemoveAll(path); err != nil {
+		log.Warnf(c, "Failed to remove %s: %s", path, err)
+	}
+}
+
+// removeImage removes an image by all of its names.
+func (g *Collector) removeImage(c cookoo.Context, policy Policy, names []string) {
+	for _, name := range names {
+		if policy.DryRun {
+			log.Infof(c, "Would remove image %s (dry run)", name)
+			continue
+		}
+		log.Infof(c, "Removing image %s", name)
+		if err := g.Images.RemoveImage(name); err != nil {
+			log.Warnf(c, "Failed to remove image %s: %s", name, err)
+		}
+	}
+}
+
+// removeDangling removes the untagged images left by rebuilding a tag.
+//
+// An image being built is dangling until it is tagged, so nothing is removed while a build
+// runs, and neither is an image created since the collection started.
+func (g *Collector) removeDangling(c cookoo.Context, policy Policy, since time.Time) {
+	list, err := g.Images.ListImages(false)
+	if err != nil {
+		log.Warnf(c, "Failed to list images: %s", err)
+		return
+	}
+	for _, image := range list {
+		if len(image.RepoTags) > 1 || (len(image.RepoTags) == 1 && image.RepoTags[0] != "<none>:<none>") {
+			continue
+		}
+		if image.Created >= since.Unix() || len(g.Builds.Building()) > 0 {
+			continue
+		}
+		g.removeImage(c, policy, []string{image.ID})
+	}
+}
+
+// byCreated sorts images newest first.
+type byCreated []docli.APIImages
+
+func (b byCreated) Len() int           { return len(b) }
+func (b byCreated) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byCreated) Less(i, j int) bool { return b[i].Created > b[j].Created }
+
+// byModTime sorts files newest first.
+type byModTime []os.FileInfo
+
+func (b byModTime) Len() int           { return len(b) }
+func (b byModTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byModTime) Less(i, j int) bool { return b[i].ModTime().After(b[j].ModTime()) }
+
+// Client is the part of the etcd client the collector uses.
+type Client interface {
+	etcd.Getter
+	etcd.Watcher
+}
+
+// Run collects garbage in the background: once when it starts, every interval, and whenever an
+// application is deleted, which removes its directory under path.
+//
+// The policy is read from etcd before each collection: $config/keep is how many builds, build
+// logs and images of an application are kept, and $config/dryRun only logs what would be
+// removed when it is "true".
+//
+// Params:
+// 	- client (Client): An Etcd client.
+// 	- docker (Images): The Docker client.
+// 	- builds (Builds): The running builds.
+// 	- gitHome (string): Defaults to /home/git.
+// 	- path (string): The directories of the applications. Defaults to /deis/services.
+// 	- config (string): Where the policy is read from. Defaults to /deis/builder/gc.
+// 	- keep (int): Used when $config/keep is not set. Defaults to 5.
+// 	- interval (time.Duration): Defaults to an hour.
+func Run(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	client := p.Get("client", nil).(Client)
+	path := p.Get("path", "/deis/services").(string)
+	config := p.Get("config", "/deis/builder/gc").(string)
+	keep := p.Get("keep", 5).(int)
+	interval := p.Get("interval", time.Hour).(time.Duration)
+	g := &Collector{
+		GitHome: p.Get("gitHome", "/home/git").(string),
+		Images:  p.Get("docker", nil).(Images),
+		Builds:  p.Get("builds", nil).(Builds),
+		Exists: func(app string) (bool, error) {
+			return appExists(client, path, app)
+		},
+	}
+
+	// collections run one at a time, and deletions during one are collected by the next
+	collect := make(chan struct{}, 1)
+	collect <- struct{}{}
+	safely.GoDo(c, func() {
+		for range collect {
+			policy := readPolicy(c, client, config, keep)
+			if err := g.Collect(c, policy); err != nil {
+				log.Errf(c, "Garbage collection failed: %s", err)
+			}
+		}
+	})
+	safely.GoDo(c, func() {
+		for range time.Tick(interval) {
+			trigger(collect)
+		}
+	})
+	safely.GoDo(c, func() {
+		var index uint64
+		for {
+			res, err := client.Watch(path, index, true, nil, nil)
+			if err != nil {
+				log.Errf(c, "Etcd Watch failed: %s", err)
+				// start over from the current index, which may have been cleared
+				index = 0
+				time.Sleep(time.Second)
+				continue
+			}
+			if res.Node == nil {
+				continue
+			}
+			index = res.Node.ModifiedIndex + 1
+			if appDeleted(path, res) {
+				trigger(collect)
+			}
+		}
+	})
+	return nil, nil
+}
+
+// trigger asks for a collection, unless one is already waiting to run.
+func trigger(collect chan<- struct{}) {
+	select {
+	case collect <- struct{}{}:
+	default:
+	}
+}
+
+// appDeleted returns whether an event deletes the directory of an application under path.
+func appDeleted(path string, res *goetcd.Response) bool {
+	switch res.Action {
+	case "delete", "expire", "compareAndDelete":
+	default:
+		return false
+	}
+	app := strings.TrimPrefix(res.Node.Key, path+"/")
+	return app != res.Node.Key && app != "" && !strings.Contains(app, "/")
+}
+
+// appExists returns whether app has a directory under path, which the controller creates with
+// the application and deletes with it.
+func appExists(client etcd.Getter, path, app string) (bool, error) {
+	_, err := client.Get(path+"/"+app, false, false)
+	if e, ok := err.(*goetcd.EtcdError); ok && e.ErrorCode == keyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// readPolicy reads the policy from etcd, keeping keep builds unless $config/keep is set.
+func readPolicy(c cookoo.Context, client etcd.Getter, config string, keep int) Policy {
+	n, _ := etcd.GetInt(c, cookoo.NewParamsWithValues(map[string]interface{}{
+		"client":  client,
+		"path":    config + "/keep",
+		"default": keep,
+	}))
+	policy := Policy{Keep: n.(int)}
+	if res, err := client.Get(config+"/dryRun", false, false); err == nil && res.Node != nil {
+		policy.DryRun = res.Node.Value == "true"
+	}
+	return policy
+}
@@ -0,0 +1,268 @@
+package gc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/cookoo"
+	goetcd "github.com/coreos/go-etcd/etcd"
+	docli "github.com/fsouza/go-dockerclient"
+)
+
+type fakeImages struct {
+	images  []docli.APIImages
+	removed []string
+}
+
+func (f *fakeImages) ListImages(all bool) ([]docli.APIImages, error) {
+	return f.images, nil
+}
+
+func (f *fakeImages) RemoveImage(name string) error {
+	f.removed = append(f.removed, name)
+	return nil
+}
+
+type fakeBuilds map[string]bool
+
+func (f fakeBuilds) Building() map[string]bool {
+	return f
+}
+
+// newTestRepo creates the repository of app in gitHome with builds and logs, the newest last.
+func newTestRepo(t *testing.T, gitHome, app string, builds, logs []string) {
+	for dir, names := range map[string][]string{"build": builds, "logs": logs} {
+		dir = filepath.Join(gitHome, app+".git", dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+			modTime := time.Now().Add(time.Duration(i-len(names)) * time.Hour)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// listRepos returns the files left in the repositories of gitHome.
+func listRepos(t *testing.T, gitHome string) []string {
+	var files []string
+	err := filepath.Walk(gitHome, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(gitHome, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func newTestCollector(t *testing.T) (*Collector, *fakeImages, string) {
+	gitHome, err := ioutil.TempDir("", "deis-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTestRepo(t, gitHome, "go-app", []string{"b1", "b2", "b3"}, []string{"1.log", "2.log", "3.log"})
+	newTestRepo(t, gitHome, "deleted-app", []string{"b1"}, []string{"1.log"})
+	newTestRepo(t, gitHome, "busy-app", []string{"b1", "b2", "b3"}, nil)
+	images := &fakeImages{images: []docli.APIImages{
+		{ID: "1", Created: 1, RepoTags: []string{"10.0.0.1:5000/go-app:git-11111111"}},
+		// an image rebuilt for another commit has two tags
+		{ID: "3", Created: 3, RepoTags: []string{"10.0.0.1:5000/go-app:git-33333333", "10.0.0.1:5000/go-app:git-3333aaaa"}},
+		{ID: "2", Created: 2, RepoTags: []string{"10.0.0.1:5000/go-app:git-22222222"}},
+		{ID: "4", Created: 4, RepoTags: []string{"10.0.0.1:5000/deleted-app:git-44444444"}},
+		// the app of this image has no repository left
+		{ID: "5", Created: 5, RepoTags: []string{"10.0.0.1:5000/gone-app:git-55555555"}},
+		// the app of this image cannot be checked
+		{ID: "6", Created: 6, RepoTags: []string{"10.0.0.1:5000/unknown-app:git-66666666"}},
+		{ID: "7", Created: 7, RepoTags: []string{"deis/slugrunner:latest"}},
+		{ID: "8", Created: 8, RepoTags: []string{"<none>:<none>"}},
+		// an image being built after the collection started
+		{ID: "9", Created: time.Now().Add(time.Hour).Unix(), RepoTags: []string{"<none>:<none>"}},
+	}}
+	g := &Collector{
+		GitHome: gitHome,
+		Images:  images,
+		Builds:  fakeBuilds{"busy-app": true},
+		Exists: func(app string) (bool, error) {
+			if app == "unknown-app" {
+				return false, fmt.Errorf("etcd is down")
+			}
+			return app == "go-app" || app == "busy-app", nil
+		},
+	}
+	return g, images, gitHome
+}
+
+func TestCollect(t *testing.T) {
+	g, images, gitHome := newTestCollector(t)
+	defer os.RemoveAll(gitHome)
+
+	if err := g.Collect(cookoo.NewContext(), Policy{Keep: 2}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"busy-app.git/build/b1",
+		"busy-app.git/build/b2",
+		"busy-app.git/build/b3",
+		"go-app.git/build/b2",
+		"go-app.git/build/b3",
+		"go-app.git/logs/2.log",
+		"go-app.git/logs/3.log",
+	}
+	if files := listRepos(t, gitHome); fmt.Sprint(files) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v to be kept, got %v", expected, files)
+	}
+	sort.Strings(images.removed)
+	expectedImages := []string{
+		"10.0.0.1:5000/deleted-app:git-44444444",
+		"10.0.0.1:5000/go-app:git-11111111",
+		"10.0.0.1:5000/gone-app:git-55555555",
+	}
+	if fmt.Sprint(images.removed) != fmt.Sprint(expectedImages) {
+		t.Errorf("Expected %v to be removed, got %v", expectedImages, images.removed)
+	}
+}
+
+func TestCollectDangling(t *testing.T) {
+	g, images, gitHome := newTestCollector(t)
+	defer os.RemoveAll(gitHome)
+	g.Builds = fakeBuilds{}
+
+	if err := g.Collect(cookoo.NewContext(), Policy{Keep: 0}); err != nil {
+		t.Fatal(err)
+	}
+	// without a limit, only the deleted apps and the dangling image are collected
+	sort.Strings(images.removed)
+	expectedImages := []string{
+		"10.0.0.1:5000/deleted-app:git-44444444",
+		"10.0.0.1:5000/gone-app:git-55555555",
+		"8",
+	}
+	if fmt.Sprint(images.removed) != fmt.Sprint(expectedImages) {
+		t.Errorf("Expected %v to be removed, got %v", expectedImages, images.removed)
+	}
+	if files := listRepos(t, gitHome); len(files) != 9 {
+		t.Errorf("Expected the repositories of live apps to be kept, got %v", files)
+	}
+}
+
+func TestCollectDryRun(t *testing.T) {
+	g, images, gitHome := newTestCollector(t)
+	defer os.RemoveAll(gitHome)
+	before := listRepos(t, gitHome)
+
+	if err := g.Collect(cookoo.NewContext(), Policy{Keep: 1, DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if after := listRepos(t, gitHome); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("Expected a dry run to keep %v, got %v", before, after)
+	}
+	if len(images.removed) != 0 {
+		t.Errorf("Expected a dry run to keep the images, got %v removed", images.removed)
+	}
+}
+
+func TestAppDeleted(t *testing.T) {
+	for _, test := range []struct {
+		action, key string
+		expected    bool
+	}{
+		{"delete", "/deis/services/go-app", true},
+		{"expire", "/deis/services/go-app", true},
+		{"compareAndDelete", "/deis/services/go-app", true},
+		{"set", "/deis/services/go-app", false},
+		{"expire", "/deis/services/go-app/go-app_v2.web.1", false},
+		{"delete", "/deis/services", false},
+		{"delete", "/deis/other/go-app", false},
+	} {
+		res := &goetcd.Response{Action: test.action, Node: &goetcd.Node{Key: test.key}}
+		if actual := appDeleted("/deis/services", res); actual != test.expected {
+			t.Errorf("Expected %s of %s to be %v, got %v", test.action, test.key, test.expected, actual)
+		}
+	}
+}
+
+// servicesClient knows of go-app only.
+type servicesClient struct {
+	values map[string]string
+}
+
+func (s *servicesClient) Get(key string, sort, recurse bool) (*goetcd.Response, error) {
+	if key == "/deis/services/go-app" {
+		return &goetcd.Response{Node: &goetcd.Node{Key: key, Dir: true}}, nil
+	}
+	if value, ok := s.values[key]; ok {
+		return &goetcd.Response{Node: &goetcd.Node{Key: key, Value: value}}, nil
+	}
+	return nil, &goetcd.EtcdError{ErrorCode: keyNotFound}
+}
+
+func TestAppExists(t *testing.T) {
+	client := &servicesClient{}
+	if exists, err := appExists(client, "/deis/services", "go-app"); !exists || err != nil {
+		t.Errorf("Expected go-app to exist, got %v, %v", exists, err)
+	}
+	if exists, err := appExists(client, "/deis/services", "deleted-app"); exists || err != nil {
+		t.Errorf("Expected deleted-app not to exist, got %v, %v", exists, err)
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	c := cookoo.NewContext()
+	if policy := readPolicy(c, &servicesClient{}, "/deis/builder/gc", 5); policy != (Policy{Keep: 5}) {
+		t.Errorf("Expected the default policy, got %+v", policy)
+	}
+	client := &servicesClient{values: map[string]string{
+		"/deis/builder/gc/keep":   "2",
+		"/deis/builder/gc/dryRun": "true",
+	}}
+	if policy := readPolicy(c, client, "/deis/builder/gc", 5); policy != (Policy{Keep: 2, DryRun: true}) {
+		t.Errorf("Expected to keep 2 builds in a dry run, got %+v", policy)
+	}
+}
+
+// startingBuilds starts building an application after it is first asked what is being built.
+type startingBuilds struct {
+	calls int
+}
+
+func (f *startingBuilds) Building() map[string]bool {
+	f.calls++
+	if f.calls > 1 {
+		return map[string]bool{"go-app": true}
+	}
+	return nil
+}
+
+func TestCollectDanglingBuildStarted(t *testing.T) {
+	g, images, gitHome := newTestCollector(t)
+	defer os.RemoveAll(gitHome)
+	g.Builds = &startingBuilds{}
+
+	if err := g.Collect(cookoo.NewContext(), Policy{Keep: 0}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range images.removed {
+		if name == "8" {
+			t.Errorf("Expected the dangling image to be kept once a build started, got %v removed", images.removed)
+		}
+	}
+}
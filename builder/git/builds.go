@@ -0,0 +1,208 @@
+package git
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// Builds schedules the pushes being built.
+//
+// The builds of an application run one at a time, in the order they were pushed, and at most
+// limit builds run at once. The others wait in a queue.
+type Builds struct {
+	mu    sync.Mutex
+	limit int
+	// queue holds the running and waiting builds, in the order they were pushed.
+	queue []*Build
+}
+
+// Build is a push being built.
+type Build struct {
+	App  string
+	User string
+	// Started is when the build left the queue.
+	Started time.Time
+
+	once    sync.Once
+	cancel  chan struct{}
+	reason  string
+	running chan struct{}
+	moved   chan int
+	// position is the build's place in the queue, or 0 once it runs.
+	position int
+}
+
+// NewBuilds returns an empty build scheduler running at most limit builds at once. A limit of
+// zero runs every build at once.
+func NewBuilds(limit int) *Builds {
+	return &Builds{limit: limit}
+}
+
+// TrackBuilds creates the scheduler of builds.
+//
+// Params:
+// 	- limit (int): How many builds may run at once. Zero means no limit.
+//
+// Returns:
+// 	- *Builds
+func TrackBuilds(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	return NewBuilds(p.Get("limit", 0).(int)), nil
+}
+
+// SetLimit changes how many builds may run at once, starting the queued builds it lets run.
+func (b *Builds) SetLimit(limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = limit
+	b.schedule()
+}
+
+// Queue queues a build of app pushed by user. The build starts once no other build of app
+// runs and there are fewer than limit builds running.
+func (b *Builds) Queue(app, user string) *Build {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	build := &Build{
+		App:     app,
+		User:    user,
+		cancel:  make(chan struct{}),
+		running: make(chan struct{}),
+		moved:   make(chan int, 1),
+	}
+	b.queue = append(b.queue, build)
+	b.schedule()
+	return build
+}
+
+// Finish forgets a build once its push has exited, or it left the queue, and starts the
+// builds which were waiting for it.
+func (b *Builds) Finish(build *Build) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, queued := range b.queue {
+		if queued == build {
+			b.queue = append(b.queue[:i], b.queue[i+1:]...)
+			break
+		}
+	}
+	b.schedule()
+}
+
+// schedule starts the queued builds which may run, and tells the others their new place in
+// the queue. It is called with the lock held.
+func (b *Builds) schedule() {
+	running := 0
+	// busy holds the applications whose next build must wait for an earlier one
+	busy := make(map[string]bool)
+	for _, build := range b.queue {
+		if build.isRunning() {
+			running++
+			busy[build.App] = true
+		}
+	}
+	position := 0
+	for _, build := range b.queue {
+		if build.isRunning() {
+			continue
+		}
+		if !busy[build.App] && (b.limit <= 0 || running < b.limit) {
+			build.position = 0
+			build.Started = time.Now()
+			close(build.running)
+			running++
+			busy[build.App] = true
+			continue
+		}
+		busy[build.App] = true
+		position++
+		if build.position != position {
+			build.position = position
+			// only the latest position matters to the push waiting for it
+			select {
+			case <-build.moved:
+			default:
+			}
+			build.moved <- position
+		}
+	}
+}
+
+// Get returns the running build of app, if any.
+func (b *Builds) Get(app string) (*Build, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, build := range b.queue {
+		if build.App == app && build.isRunning() {
+			return build, true
+		}
+	}
+	return nil, false
+}
+
+// Building returns the applications with a running or queued build.
+func (b *Builds) Building() map[string]bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	apps := make(map[string]bool, len(b.queue))
+	for _, build := range b.queue {
+		apps[build.App] = true
+	}
+	return apps
+}
+
+// Cancel cancels the running and queued builds of app. It returns false if app is not being
+// built.
+func (b *Builds) Cancel(app, reason string) bool {
+	b.mu.Lock()
+	var builds []*Build
+	for _, build := range b.queue {
+		if build.App == app {
+			builds = append(builds, build)
+		}
+	}
+	b.mu.Unlock()
+	for _, build := range builds {
+		build.Cancel(reason)
+	}
+	return len(builds) > 0
+}
+
+func (b *Build) isRunning() bool {
+	select {
+	case <-b.running:
+		return true
+	default:
+		return false
+	}
+}
+
+// Running is closed when the build leaves the queue.
+func (b *Build) Running() <-chan struct{} {
+	return b.running
+}
+
+// Moved receives the build's new place in the queue, starting from 1, when it changes.
+func (b *Build) Moved() <-chan int {
+	return b.moved
+}
+
+// Cancel asks for the build to be stopped. Only the first reason is kept.
+func (b *Build) Cancel(reason string) {
+	b.once.Do(func() {
+		b.reason = reason
+		close(b.cancel)
+	})
+}
+
+// Cancelled is closed when the build is cancelled.
+func (b *Build) Cancelled() <-chan struct{} {
+	return b.cancel
+}
+
+// Reason returns why the build was cancelled. It is only set once Cancelled is closed.
+func (b *Build) Reason() string {
+	<-b.cancel
+	return b.reason
+}
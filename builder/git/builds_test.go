@@ -0,0 +1,205 @@
+package git
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/cookoo"
+)
+
+// running returns whether a build has left the queue.
+func running(build *Build) bool {
+	select {
+	case <-build.Running():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestBuilds(t *testing.T) {
+	builds := NewBuilds(2)
+	goApp := builds.Queue("go-app", "alice")
+	goApp2 := builds.Queue("go-app", "bob")
+	rubyApp := builds.Queue("ruby-app", "bob")
+	pythonApp := builds.Queue("python-app", "carol")
+
+	if !running(goApp) || !running(rubyApp) {
+		t.Fatal("Expected go-app and ruby-app to be built.")
+	}
+	if running(goApp2) {
+		t.Error("Expected the second build of go-app to wait for the first.")
+	}
+	if running(pythonApp) {
+		t.Error("Expected python-app to wait for a running build to finish.")
+	}
+	if position := <-goApp2.Moved(); position != 1 {
+		t.Errorf("Expected the second build of go-app to be first in the queue, got %d", position)
+	}
+	if position := <-pythonApp.Moved(); position != 2 {
+		t.Errorf("Expected python-app to be second in the queue, got %d", position)
+	}
+	if build, ok := builds.Get("go-app"); !ok || build != goApp {
+		t.Error("Expected the first build of go-app to be running.")
+	}
+	if building := builds.Building(); len(building) != 3 || !building["python-app"] {
+		t.Errorf("Expected the running and queued apps to be building, got %v", building)
+	}
+
+	// the second build of go-app still waits for the first, so python-app goes ahead
+	builds.Finish(rubyApp)
+	if !running(pythonApp) || running(goApp2) {
+		t.Error("Expected python-app to be built in place of ruby-app.")
+	}
+	select {
+	case position := <-goApp2.Moved():
+		t.Errorf("Expected go-app to stay first in the queue, got %d", position)
+	default:
+	}
+	builds.Finish(goApp)
+	if !running(goApp2) {
+		t.Error("Expected the second build of go-app to follow the first.")
+	}
+
+	// queued builds are cancelled with the running ones
+	goApp3 := builds.Queue("go-app", "alice")
+	if !builds.Cancel("go-app", "build cancelled by alice") {
+		t.Error("Expected go-app to be cancelled.")
+	}
+	builds.Cancel("go-app", "build cancelled by bob")
+	for _, build := range []*Build{goApp2, goApp3} {
+		select {
+		case <-build.Cancelled():
+		default:
+			t.Fatal("Expected the builds of go-app to be cancelled.")
+		}
+		if reason := build.Reason(); reason != "build cancelled by alice" {
+			t.Errorf("Expected the first reason to be kept, got %s", reason)
+		}
+	}
+	if builds.Cancel("ruby-app", "build cancelled") {
+		t.Error("Expected ruby-app not to be cancelled.")
+	}
+
+	builds.Finish(goApp3)
+	builds.Finish(goApp2)
+	if _, ok := builds.Get("go-app"); ok {
+		t.Error("Expected go-app to be finished.")
+	}
+}
+
+func TestBuildsSetLimit(t *testing.T) {
+	builds := NewBuilds(1)
+	goApp := builds.Queue("go-app", "alice")
+	rubyApp := builds.Queue("ruby-app", "bob")
+	if !running(goApp) || running(rubyApp) {
+		t.Fatal("Expected only one build to run.")
+	}
+	builds.SetLimit(0)
+	if !running(rubyApp) {
+		t.Error("Expected every build to run without a limit.")
+	}
+}
+
+func TestWaitTurn(t *testing.T) {
+	_, _, cxt := cookoo.Cookoo()
+	builds := NewBuilds(1)
+	goApp := builds.Queue("go-app", "alice")
+	rubyApp := builds.Queue("ruby-app", "bob")
+	var stderr bytes.Buffer
+
+	done := make(chan error)
+	go func() { done <- waitTurn(cxt, rubyApp, nil, &stderr) }()
+	time.Sleep(50 * time.Millisecond)
+	builds.Finish(goApp)
+	if err := <-done; err != nil {
+		t.Errorf("Expected ruby-app to be built, got %s", err)
+	}
+	if expected := "ruby-app is number 1 in the queue"; !strings.Contains(stderr.String(), expected) {
+		t.Errorf("Expected the user to be told '%s', got %s", expected, stderr.String())
+	}
+
+	// clients which disconnect leave the queue
+	pythonApp := builds.Queue("python-app", "carol")
+	disconnected := make(chan struct{})
+	close(disconnected)
+	stderr.Reset()
+	err := waitTurn(cxt, pythonApp, disconnected, &stderr)
+	if err == nil || !strings.Contains(err.Error(), "client disconnected") {
+		t.Errorf("Expected python-app to be stopped, got %v", err)
+	}
+}
+
+// startPush starts a shell script standing in for a push.
+func startPush(t *testing.T, script string) *exec.Cmd {
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func TestWait(t *testing.T) {
+	_, _, cxt := cookoo.Cookoo()
+	build := NewBuilds(0).Queue("go-app", "alice")
+	var stderr bytes.Buffer
+
+	if err := wait(cxt, startPush(t, "exit 0"), build, time.Minute, nil, &stderr); err != nil {
+		t.Errorf("Expected the push to succeed, got %s", err)
+	}
+	if err := wait(cxt, startPush(t, "exit 1"), nil, 0, nil, &stderr); err == nil {
+		t.Error("Expected the push to fail.")
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("Expected no output, got %s", stderr.String())
+	}
+}
+
+func TestWaitStopped(t *testing.T) {
+	_, _, cxt := cookoo.Cookoo()
+	killGrace = 100 * time.Millisecond
+
+	tests := []struct {
+		timeout time.Duration
+		cancel  bool
+		// disconnect closes the client's connection.
+		disconnect bool
+		script     string
+		expected   string
+	}{
+		{50 * time.Millisecond, false, false, "sleep 10", "build timed out after 50ms"},
+		{0, true, false, "sleep 10", "build cancelled by alice"},
+		{time.Minute, false, true, "sleep 10", "client disconnected"},
+		// pushes which do not stop are killed
+		{0, true, false, "trap '' TERM; sleep 10", "build cancelled by alice"},
+	}
+
+	for _, test := range tests {
+		build := NewBuilds(0).Queue("go-app", "alice")
+		disconnected := make(chan struct{})
+		if test.cancel {
+			go build.Cancel("build cancelled by alice")
+		}
+		if test.disconnect {
+			close(disconnected)
+		}
+
+		var stderr bytes.Buffer
+		start := time.Now()
+		err := wait(cxt, startPush(t, test.script), build, test.timeout, disconnected, &stderr)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected the build to be stopped with '%s', got %v", test.expected, err)
+		}
+		if !strings.Contains(stderr.String(), test.expected+", stopping the build") {
+			t.Errorf("Expected the user to be told '%s', got %s", test.expected, stderr.String())
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected the build to be stopped promptly, took %s", elapsed)
+		}
+	}
+}
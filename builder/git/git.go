@@ -13,7 +13,9 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/cookoo"
 	"github.com/Masterminds/cookoo/log"
@@ -32,46 +34,51 @@ strip_remote_prefix() {
 
 set -eo pipefail
 
+# the builder runs the pushes to a repo one at a time, so no lock is needed here
 while read oldrev newrev refname
 do
-  LOCKFILE="/tmp/$RECEIVE_REPO.lock"
-  if ( set -o noclobber; echo "$$" > "$LOCKFILE" ) 2> /dev/null; then
-	trap 'rm -f "$LOCKFILE"; exit 1' INT TERM EXIT
-
-	# check for authorization on this repo
-	{{.GitHome}}/receiver "$RECEIVE_REPO" "$newrev" "$RECEIVE_USER" "$RECEIVE_FINGERPRINT"
-	rc=$?
-	if [[ $rc != 0 ]] ; then
-	  echo "      ERROR: failed on rev $newrev - push denied"
-	  exit $rc
-	fi
-	# builder assumes that we are running this script from $GITHOME
-	cd {{.GitHome}}
-	# if we're processing a receive-pack on an existing repo, run a build
-	if [[ $SSH_ORIGINAL_COMMAND == git-receive-pack* ]]; then
-		{{.GitHome}}/builder "$RECEIVE_USER" "$RECEIVE_REPO" "$newrev" 2>&1 | strip_remote_prefix
-	fi
-
-	rm -f "$LOCKFILE"
-	trap - INT TERM EXIT
-  else
-	echo "Another git push is ongoing. Aborting..."
-	exit 1
+  # check for authorization on this repo
+  {{.GitHome}}/receiver "$RECEIVE_REPO" "$newrev" "$RECEIVE_USER" "$RECEIVE_FINGERPRINT"
+  rc=$?
+  if [[ $rc != 0 ]] ; then
+    echo "      ERROR: failed on rev $newrev - push denied"
+    exit $rc
+  fi
+  # builder assumes that we are running this script from $GITHOME
+  cd {{.GitHome}}
+  # if we're processing a receive-pack on an existing repo, run a build. The builder only
+  # deploys the app's deploy branch, and refuses to delete it.
+  if [[ $SSH_ORIGINAL_COMMAND == git-receive-pack* ]]; then
+    {{.GitHome}}/builder "$RECEIVE_USER" "$RECEIVE_REPO" "$newrev" "$refname" 2>&1 | strip_remote_prefix
   fi
 done
 `
 
-// Receive receives a Git repo.
-// This will only work for git-receive-pack.
+// killGrace is how long a stopped push has to exit before it is killed.
+var killGrace = 30 * time.Second
+
+// Receive receives a Git repo, or sends it for a git-upload-pack.
+//
+// A git-upload-pack (a clone or fetch) is only allowed to the users the controller lets push
+// to the application, and never creates the repository.
+//
+// A git-receive-pack is queued as a build of the application, and the user is told its place
+// in the queue until it runs. The build is stopped if it is cancelled, if it runs longer than
+// the timeout, or if the client disconnects.
 //
 // Params:
-// 	- operation (string): e.g. git-receive-pack
+// 	- operation (string): git-receive-pack or git-upload-pack
 // 	- repoName (string): The repository name, in the form '/REPO.git'.
 // 	- channel (ssh.Channel): The channel.
 // 	- request (*ssh.Request): The channel.
 // 	- gitHome (string): Defaults to /home/git.
-// 	- fingerprint (string): The fingerprint of the user's SSH key.
+// 	- fingerprint (string): The MD5 fingerprint of the user's SSH key.
+// 	- sha256Fingerprint (string): The SHA256 fingerprint of the user's SSH key.
 // 	- user (string): The name of the Deis user.
+// 	- builds (*Builds): The build queue. If not set, builds are not queued.
+// 	- maxBuilds (int): How many builds may run at once. Zero means no limit.
+// 	- timeout (time.Duration): How long a build may run once out of the queue. Zero means no limit.
+// 	- disconnected (<-chan struct{}): Closed when the client disconnects.
 //
 // Returns:
 // 	- nothing
@@ -84,17 +91,46 @@ func Receive(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 	channel := p.Get("channel", nil).(ssh.Channel)
 	gitHome := p.Get("gitHome", "/home/git").(string)
 	fingerprint := p.Get("fingerprint", nil).(string)
+	sha256Fingerprint := p.Get("sha256Fingerprint", "").(string)
 	user := p.Get("user", "").(string)
+	builds, _ := p.Get("builds", nil).(*Builds)
+	maxBuilds := p.Get("maxBuilds", 0).(int)
+	timeout := p.Get("timeout", time.Duration(0)).(time.Duration)
+	disconnected, _ := p.Get("disconnected", nil).(<-chan struct{})
 
-	repo, err := cleanRepoName(repoName)
+	app, err := cleanRepoName(repoName)
 	if err != nil {
 		log.Warnf(c, "Illegal repo name: %s.", err)
 		channel.Stderr().Write([]byte("No repo given"))
 		return nil, err
 	}
-	repo += ".git"
+	repo := app + ".git"
 
-	if _, err := createRepo(c, filepath.Join(gitHome, repo), gitHome); err != nil {
+	var build *Build
+	if operation == "git-receive-pack" && builds != nil {
+		builds.SetLimit(maxBuilds)
+		build = builds.Queue(app, user)
+		defer builds.Finish(build)
+		if err := waitTurn(c, build, disconnected, channel.Stderr()); err != nil {
+			return nil, err
+		}
+	}
+
+	repoPath := filepath.Join(gitHome, repo)
+	if operation == "git-upload-pack" {
+		// clones and fetches are allowed to the users who may push to the app
+		if err := authorizeFetch(gitHome, repo, user); err != nil {
+			log.Warnf(c, "Rejected fetch of %s by %s: %s", app, user, err)
+			channel.Stderr().Write([]byte(fmt.Sprintf("Access to %s denied\n", app)))
+			return nil, err
+		}
+		// only pushes create repositories
+		if _, err := os.Stat(filepath.Join(repoPath, "config")); err != nil {
+			log.Warnf(c, "Rejected fetch of %s: %s", app, err)
+			channel.Stderr().Write([]byte(fmt.Sprintf("Nothing has been pushed to %s yet\n", app)))
+			return nil, err
+		}
+	} else if _, err := createRepo(c, repoPath, gitHome); err != nil {
 		log.Infof(c, "Did not create new repo: %s", err)
 	}
 	cmd := exec.Command("git-shell", "-c", fmt.Sprintf("%s '%s'", operation, repo))
@@ -107,10 +143,13 @@ func Receive(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 		fmt.Sprintf("RECEIVE_USER=%s", user),
 		fmt.Sprintf("RECEIVE_REPO=%s", repo),
 		fmt.Sprintf("RECEIVE_FINGERPRINT=%s", fingerprint),
+		fmt.Sprintf("RECEIVE_FINGERPRINT_SHA256=%s", sha256Fingerprint),
 		fmt.Sprintf("SSH_ORIGINAL_COMMAND=%s '%s'", operation, repo),
 		fmt.Sprintf("SSH_CONNECTION=%s", c.Get("SSH_CONNECTION", "0 0 0 0").(string)),
 	}
 	cmd.Env = append(cmd.Env, os.Environ()...)
+	// run the push in its own process group, so the build can be stopped as a whole
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	done := plumbCommand(cmd, channel, &errbuff)
 
@@ -121,7 +160,7 @@ func Receive(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 	fmt.Printf("Waiting for git-receive to run.\n")
 	done.Wait()
 	fmt.Printf("Waiting for deploy.\n")
-	if err := cmd.Wait(); err != nil {
+	if err := wait(c, cmd, build, timeout, disconnected, channel.Stderr()); err != nil {
 		log.Errf(c, "Error on command: %s %s", err, errbuff.Bytes())
 		return nil, err
 	}
@@ -133,6 +172,81 @@ func Receive(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 	return nil, nil
 }
 
+// waitTurn waits for a queued build to run, telling the user its place in the queue. It fails
+// if the build is cancelled or the client disconnects first.
+func waitTurn(c cookoo.Context, build *Build, disconnected <-chan struct{}, stderr io.Writer) error {
+	for {
+		select {
+		case <-build.Running():
+		case position := <-build.Moved():
+			fmt.Fprintf(stderr, "-----> Waiting for other builds to finish, %s is number %d in the queue\n", build.App, position)
+			continue
+		case <-disconnected:
+			build.Cancel("client disconnected")
+		case <-build.Cancelled():
+		}
+		select {
+		case <-build.Cancelled():
+			reason := build.Reason()
+			log.Warnf(c, "Dropping the queued build of %s: %s", build.App, reason)
+			fmt.Fprintf(stderr, " !     %s, stopping the build\n", reason)
+			return fmt.Errorf("build of %s stopped: %s", build.App, reason)
+		default:
+			return nil
+		}
+	}
+}
+
+// wait waits for a push to exit. If the push is a build, it is stopped when the build is
+// cancelled, runs longer than timeout, or the client disconnects.
+func wait(c cookoo.Context, cmd *exec.Cmd, build *Build, timeout time.Duration, disconnected <-chan struct{}, stderr io.Writer) error {
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	if build == nil {
+		return <-exited
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-exited:
+		return err
+	case <-expired:
+		build.Cancel(fmt.Sprintf("build timed out after %s", timeout))
+	case <-disconnected:
+		build.Cancel("client disconnected")
+	case <-build.Cancelled():
+	}
+
+	reason := build.Reason()
+	log.Warnf(c, "Stopping the build of %s: %s", build.App, reason)
+	fmt.Fprintf(stderr, "\n !     %s, stopping the build\n", reason)
+	// the builder removes its containers when it is terminated
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(killGrace):
+		log.Warnf(c, "Killing the build of %s", build.App)
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-exited
+	}
+	return fmt.Errorf("build of %s stopped: %s", build.App, reason)
+}
+
+// authorizeFetch asks the controller whether user may fetch repo, with the fetcher script
+// rendered by confd into gitHome.
+func authorizeFetch(gitHome, repo, user string) error {
+	cmd := exec.Command(filepath.Join(gitHome, "fetcher"), repo, user)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("fetcher: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
 func execAs(user, cmd string, args ...string) *exec.Cmd {
 	fullCmd := cmd + " " + strings.Join(args, " ")
 	return exec.Command("su", user, "-c", fullCmd)
@@ -184,13 +298,16 @@ func initRepo(repoPath, gitHome string, c cookoo.Context) (bool, error) {
 		return false, err
 	}
 
+	return true, writeHook(repoPath, gitHome)
+}
+
+// writeHook writes the pre-receive hook of the repository at repoPath.
+func writeHook(repoPath, gitHome string) error {
 	hook, err := prereceiveHook(map[string]string{"GitHome": gitHome})
 	if err != nil {
-		return true, err
+		return err
 	}
-	ioutil.WriteFile(filepath.Join(repoPath, "hooks", "pre-receive"), hook, 0755)
-
-	return true, nil
+	return ioutil.WriteFile(filepath.Join(repoPath, "hooks", "pre-receive"), hook, 0755)
 }
 
 // createRepo creates a new Git repo if it is not present already.
@@ -208,7 +325,8 @@ func createRepo(c cookoo.Context, repoPath, gitHome string) (bool, error) {
 			configPath := filepath.Join(repoPath, "config")
 			if _, cerr := os.Stat(configPath); cerr == nil {
 				log.Debugf(c, "Directory '%s' already exists.", repoPath)
-				return true, nil
+				// repositories created by an older builder get the current hook
+				return true, writeHook(repoPath, gitHome)
 			} else {
 				log.Warnf(c, "No config file found at `%s`; removing it and recreating.", repoPath)
 				if err := os.RemoveAll(repoPath); err != nil {
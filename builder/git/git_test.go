@@ -0,0 +1,39 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthorizeFetch(t *testing.T) {
+	gitHome, err := ioutil.TempDir("", "git-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gitHome)
+
+	// the fetcher only lets alice fetch go-app
+	fetcher := "#!/bin/sh\n[ \"$1\" = go-app.git ] && [ \"$2\" = alice ] || { echo denied; exit 22; }\n"
+	if err := ioutil.WriteFile(filepath.Join(gitHome, "fetcher"), []byte(fetcher), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := authorizeFetch(gitHome, "go-app.git", "alice"); err != nil {
+		t.Errorf("Expected alice to fetch go-app, got %s", err)
+	}
+	err = authorizeFetch(gitHome, "go-app.git", "mallory")
+	if err == nil {
+		t.Fatal("Expected mallory not to fetch go-app.")
+	}
+	if !strings.Contains(err.Error(), "denied") {
+		t.Errorf("Expected the fetcher's output in %q", err)
+	}
+
+	os.Remove(filepath.Join(gitHome, "fetcher"))
+	if err := authorizeFetch(gitHome, "go-app.git", "alice"); err == nil {
+		t.Error("Expected fetches to be denied without a fetcher.")
+	}
+}
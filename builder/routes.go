@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"runtime"
 	"time"
 
 	"github.com/Masterminds/cookoo"
@@ -9,6 +10,7 @@ import (
 	"github.com/deis/deis/builder/docker"
 	"github.com/deis/deis/builder/env"
 	"github.com/deis/deis/builder/etcd"
+	"github.com/deis/deis/builder/gc"
 	"github.com/deis/deis/builder/git"
 	"github.com/deis/deis/builder/sshd"
 )
@@ -175,13 +177,31 @@ func routes(reg *cookoo.Registry) {
 				},
 			},
 
-			// ETDCD: Now watch for events on etcd, and trigger a git check-repos for
-			// each. For the most part, this runs in the background.
+			// BUILDS: Queue the builds, and cancel them when the controller asks us to.
 			cookoo.Cmd{
-				Name: "Cleanup",
-				Fn:   etcd.Watch,
+				Name: "builds",
+				Fn:   git.TrackBuilds,
+			},
+			cookoo.Cmd{
+				Name: "watchCancel",
+				Fn:   etcd.WatchCancel,
+				Using: []cookoo.Param{
+					{Name: "client", From: "cxt:client"},
+					{Name: "builds", From: "cxt:builds"},
+				},
+			},
+
+			// GC: Remove what deleted apps leave behind, and old builds, logs and images.
+			// This runs in the background, when apps are deleted and every hour.
+			cookoo.Cmd{
+				Name: "gc",
+				Fn:   gc.Run,
 				Using: []cookoo.Param{
 					{Name: "client", From: "cxt:client"},
+					{Name: "docker", From: "cxt:docker"},
+					{Name: "builds", From: "cxt:builds"},
+					{Name: "keep", DefaultValue: 5},
+					{Name: "interval", DefaultValue: time.Hour},
 				},
 			},
 			// If there's an EXTERNAL_PORT, we publish info to etcd.
@@ -218,7 +238,8 @@ func routes(reg *cookoo.Registry) {
 
 	// This route is called during a user authentication for SSH.
 	// The rough pattern is that we parse the local authorized keys file, and
-	// then validate that the supplied user key matches an authorized key.
+	// then validate that the supplied user key matches an authorized key, or
+	// that the supplied certificate is signed by a trusted authority.
 	//
 	// This grants access to running git-receive, but does not grant access
 	// to writing to the repo. That's handled by the sshReceive.
@@ -236,6 +257,15 @@ func routes(reg *cookoo.Registry) {
 				},
 			},
 
+			// The certificate authorities are rendered by confd too.
+			cookoo.Cmd{
+				Name: "authorities",
+				Fn:   sshd.ParseAuthorities,
+				Using: []cookoo.Param{
+					{Name: "path", DefaultValue: "/home/git/.ssh/user_ca.pub"},
+				},
+			},
+
 			// Auth against the keys
 			cookoo.Cmd{
 				Name: "authN",
@@ -244,6 +274,7 @@ func routes(reg *cookoo.Registry) {
 					{Name: "metadata", From: "cxt:metadata"},
 					{Name: "key", From: "cxt:key"},
 					{Name: "authorizedKeys", From: "cxt:authorizedKeys"},
+					{Name: "authorities", From: "cxt:authorities"},
 				},
 			},
 		},
@@ -279,9 +310,18 @@ func routes(reg *cookoo.Registry) {
 			// ditch this.
 			cookoo.Cmd{
 				Name: "fingerprint",
-				Fn:   sshd.FingerprintKey,
+				Fn:   sshd.Extension,
 				Using: []cookoo.Param{
-					{Name: "key", From: "cxt:key"},
+					{Name: "permissions", From: "cxt:permissions"},
+					{Name: "name", DefaultValue: "fingerprint"},
+				},
+			},
+			cookoo.Cmd{
+				Name: "sha256Fingerprint",
+				Fn:   sshd.Extension,
+				Using: []cookoo.Param{
+					{Name: "permissions", From: "cxt:permissions"},
+					{Name: "name", DefaultValue: "sha256-fingerprint"},
 				},
 			},
 			cookoo.Cmd{
@@ -290,6 +330,26 @@ func routes(reg *cookoo.Registry) {
 				Using: []cookoo.Param{
 					{Name: "client", From: "cxt:client"},
 					{Name: "fingerprint", From: "cxt:fingerprint"},
+					{Name: "sha256Fingerprint", From: "cxt:sha256Fingerprint"},
+					{Name: "permissions", From: "cxt:permissions"},
+				},
+			},
+			cookoo.Cmd{
+				Name: "buildTimeout",
+				Fn:   etcd.GetDuration,
+				Using: []cookoo.Param{
+					{Name: "client", From: "cxt:client"},
+					{Name: "path", DefaultValue: "/deis/builder/buildTimeout"},
+					{Name: "default", DefaultValue: 30 * time.Minute},
+				},
+			},
+			cookoo.Cmd{
+				Name: "maxBuilds",
+				Fn:   etcd.GetInt,
+				Using: []cookoo.Param{
+					{Name: "client", From: "cxt:client"},
+					{Name: "path", DefaultValue: "/deis/builder/maxBuilds"},
+					{Name: "default", DefaultValue: runtime.NumCPU()},
 				},
 			},
 			cookoo.Cmd{
@@ -301,8 +361,13 @@ func routes(reg *cookoo.Registry) {
 					{Name: "operation", From: "cxt:operation"},
 					{Name: "repoName", From: "cxt:repository"},
 					{Name: "fingerprint", From: "cxt:fingerprint"},
-					{Name: "permissions", From: "cxt:authN"},
+					{Name: "sha256Fingerprint", From: "cxt:sha256Fingerprint"},
+					{Name: "permissions", From: "cxt:permissions"},
 					{Name: "user", From: "cxt:username"},
+					{Name: "builds", From: "cxt:builds"},
+					{Name: "maxBuilds", From: "cxt:maxBuilds"},
+					{Name: "timeout", From: "cxt:buildTimeout"},
+					{Name: "disconnected", From: "cxt:disconnected"},
 				},
 			},
 		},
@@ -118,7 +118,7 @@ func (s *server) listen(l net.Listener, conf *ssh.ServerConfig, closer chan inte
 func (s *server) handleConn(conn net.Conn, conf *ssh.ServerConfig) {
 	defer conn.Close()
 	log.Info(s.c, "Accepted connection.")
-	_, chans, reqs, err := ssh.NewServerConn(conn, conf)
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, conf)
 	if err != nil {
 		// Handshake failure.
 		log.Debugf(s.c, "Failed handshake: %s", err)
@@ -129,6 +129,9 @@ func (s *server) handleConn(conn net.Conn, conf *ssh.ServerConfig) {
 	safely.GoDo(s.c, func() { ssh.DiscardRequests(reqs) })
 
 	condata := sshConnection(conn)
+	// Closed once the client goes away, so that its builds can be stopped.
+	disconnected := make(chan struct{})
+	defer close(disconnected)
 
 	// Now we handle the channels.
 	for incoming := range chans {
@@ -142,7 +145,7 @@ func (s *server) handleConn(conn net.Conn, conf *ssh.ServerConfig) {
 			// Should close request and move on.
 			panic(err)
 		}
-		safely.GoDo(s.c, func() { s.answer(channel, req, condata) })
+		safely.GoDo(s.c, func() { s.answer(channel, req, condata, sconn.Permissions, disconnected) })
 	}
 	conn.Close()
 }
@@ -173,7 +176,10 @@ func sendExitStatus(status uint32, channel ssh.Channel) error {
 // correct behavior for a failed exec is.
 //
 // Support for setting environment variables via `env` has been disabled.
-func (s *server) answer(channel ssh.Channel, requests <-chan *ssh.Request, sshConn string) error {
+//
+// The permissions the connection was authenticated with are put into the
+// context as "permissions".
+func (s *server) answer(channel ssh.Channel, requests <-chan *ssh.Request, sshConn string, perms *ssh.Permissions, disconnected <-chan struct{}) error {
 	defer channel.Close()
 
 	// Answer all the requests on this connection.
@@ -198,6 +204,7 @@ func (s *server) answer(channel ssh.Channel, requests <-chan *ssh.Request, sshCo
 			// We need a shallow copy of the context to avoid race conditions.
 			cxt := s.c.Copy()
 			cxt.Put("SSH_CONNECTION", sshConn)
+			cxt.Put("permissions", perms)
 
 			// Only allow commands that we know about.
 			switch parts[0] {
@@ -222,6 +229,7 @@ func (s *server) answer(channel ssh.Channel, requests <-chan *ssh.Request, sshCo
 				cxt.Put("request", req)
 				cxt.Put("operation", parts[0])
 				cxt.Put("repository", parts[1])
+				cxt.Put("disconnected", disconnected)
 				sshGitReceive := cxt.Get("route.sshd.sshGitReceive", "sshGitReceive").(string)
 				err := router.HandleRequest(sshGitReceive, cxt, true)
 				var xs uint32
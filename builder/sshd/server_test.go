@@ -1,7 +1,10 @@
 package sshd
 
 import (
+	"crypto/rand"
+	"fmt"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -72,6 +75,103 @@ func TestServer(t *testing.T) {
 	closer <- true
 }
 
+// TestServerPermissions tests that overlapping connections keep the permissions they were
+// authenticated with.
+func TestServerPermissions(t *testing.T) {
+	hostKey, err := sshTestingHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := sshTestingClientKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the host key doubles as the certificate authority
+	cert := &ssh.Certificate{
+		Key:             clientKey.PublicKey(),
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"admin"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, hostKey); err != nil {
+		t.Fatal(err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg, router, cxt := cookoo.Cookoo()
+	cxt.Put("cookoo.Router", router)
+	cxt.Put(Address, "127.0.0.1:2245")
+	res, _ := Configure(cxt, cookoo.NewParamsWithValues(nil))
+	cfg := res.(*ssh.ServerConfig)
+	cfg.AddHostKey(hostKey)
+	cxt.Put(ServerConfig, cfg)
+
+	reg.Route("pubkeyAuth", "Authenticates a key or a certificate.").
+		Does(AuthKey, "authN").
+		Using("metadata").From("cxt:metadata").
+		Using("key").From("cxt:key").
+		Using("authorizedKeys").WithDefault([]string{testingClientPubKey}).
+		Using("authorities").WithDefault([]ssh.PublicKey{hostKey.PublicKey()})
+	reg.Route("sshGitReceive", "Writes the principal of the connection.").
+		Does(writePrincipal, "principal").
+		Using("channel").From("cxt:channel").
+		Using("permissions").From("cxt:permissions")
+
+	go func() {
+		if err := Serve(reg, router, cxt); err != nil {
+			t.Errorf("Failed serving with %s", err)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		signer, expected := clientKey, "principal: "
+		if i%2 == 0 {
+			signer, expected = certSigner, "principal: admin"
+		}
+		wg.Add(1)
+		go func(signer ssh.Signer, expected string) {
+			defer wg.Done()
+			client, err := ssh.Dial("tcp", "127.0.0.1:2245", &ssh.ClientConfig{
+				User: "git",
+				Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			})
+			if err != nil {
+				t.Errorf("Failed to connect client to local server: %s", err)
+				return
+			}
+			defer client.Close()
+			sess, err := client.NewSession()
+			if err != nil {
+				t.Errorf("Failed to create client session: %s", err)
+				return
+			}
+			defer sess.Close()
+			if out, err := sess.Output("git-receive-pack 'repo.git'"); err != nil {
+				t.Errorf("Output '%s' Error %s", out, err)
+			} else if string(out) != expected {
+				t.Errorf("Expected '%s', got '%s'", expected, out)
+			}
+		}(signer, expected)
+	}
+	wg.Wait()
+
+	closer := cxt.Get("sshd.Closer", nil).(chan interface{})
+	closer <- true
+}
+
+// writePrincipal writes the principal of the connection to its channel.
+func writePrincipal(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	channel := p.Get("channel", nil).(ssh.Channel)
+	perms := p.Get("permissions", nil).(*ssh.Permissions)
+	_, err := fmt.Fprintf(channel, "principal: %s", perms.Extensions["principal"])
+	return nil, err
+}
+
 // sshTestingHostKey loads the testing key.
 func sshTestingHostKey() (ssh.Signer, error) {
 	return ssh.ParsePrivateKey([]byte(testingHostKey))
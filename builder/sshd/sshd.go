@@ -3,7 +3,9 @@ package sshd
 import (
 	"bufio"
 	"crypto/md5"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
@@ -51,6 +53,40 @@ func ParseAuthorizedKeys(c cookoo.Context, p *cookoo.Params) (interface{}, cooko
 
 }
 
+// ParseAuthorities reads the public keys of the certificate authorities trusted to sign user
+// certificates, in authorized_keys format.
+//
+// A missing file means no authority is trusted.
+//
+// Params:
+// 	- path (string): The path to the file of authority keys.
+//
+// Returns:
+//  []ssh.PublicKey of authorities.
+//
+func ParseAuthorities(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	path := p.Get("path", "~/.ssh/user_ca.pub").(string)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []ssh.PublicKey{}, nil
+	} else if err != nil {
+		return []ssh.PublicKey{}, err
+	}
+
+	authorities := []ssh.PublicKey{}
+	for len(data) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			// no key is left, as ParseAuthorizedKey skips the lines it cannot parse
+			break
+		}
+		authorities = append(authorities, key)
+		data = rest
+	}
+	return authorities, nil
+}
+
 // ParseHostKeys parses the host key files.
 //
 // By default it looks in /etc/ssh for host keys of the patterh ssh_host_{{TYPE}}_key.
@@ -92,12 +128,17 @@ func ParseHostKeys(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Inte
 	return hostKeys, nil
 }
 
-// AuthKey authenticates based on a public key.
+// AuthKey authenticates based on a public key or a user certificate.
+//
+// A certificate must be signed by one of the authorities, and be valid at the time. Its first
+// principal is the name of the Deis user, which is put into the "principal" extension of the
+// permissions.
 //
 // Params:
 // 	- metadata (ssh.ConnMetadata)
 // 	- key (ssh.PublicKey)
 // 	- authorizedKeys ([]string): List of lines from an authorized keys file.
+// 	- authorities ([]ssh.PublicKey): Keys of the authorities trusted to sign user certificates.
 //
 // Returns:
 // 	*ssh.Permissions
@@ -106,8 +147,41 @@ func AuthKey(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 	meta := p.Get("metadata", nil).(ssh.ConnMetadata)
 	key := p.Get("key", nil).(ssh.PublicKey)
 	authorized := p.Get("authorizedKeys", []string{}).([]string)
+	authorities := p.Get("authorities", []ssh.PublicKey{}).([]ssh.PublicKey)
 
 	auth := new(ssh.CertChecker)
+	auth.IsAuthority = func(k ssh.PublicKey) bool {
+		for _, authority := range authorities {
+			if compareKeys(k, authority) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		if cert.CertType != ssh.UserCert {
+			return nil, fmt.Errorf("Certificate is not a user certificate.")
+		}
+		// certificates valid for any principal do not name a Deis user
+		if len(cert.ValidPrincipals) == 0 {
+			return nil, fmt.Errorf("Certificate has no principal.")
+		}
+		principal := cert.ValidPrincipals[0]
+		if err := auth.CheckCert(principal, cert); err != nil {
+			log.Infof(c, "Certificate rejected for principal %s: %s", principal, err)
+			return nil, err
+		}
+		log.Infof(c, "Certificate accepted for principal %s.", principal)
+		perm := &ssh.Permissions{
+			// the source-address option is enforced by the SSH server
+			CriticalOptions: cert.CriticalOptions,
+			Extensions:      extensions(meta, cert.Key),
+		}
+		perm.Extensions["principal"] = principal
+		return perm, nil
+	}
+
 	auth.UserKeyFallback = func(meta ssh.ConnMetadata, pk ssh.PublicKey) (*ssh.Permissions, error) {
 
 		// This gives us a string in the form "ssh-rsa LONG_KEY"
@@ -127,9 +201,7 @@ func AuthKey(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 			if allowed.Type() == suppliedType && subtle.ConstantTimeCompare(allowed.Marshal(), supplied) == 1 {
 				log.Infof(c, "Key accepted for user %s.", meta.User())
 				perm := &ssh.Permissions{
-					Extensions: map[string]string{
-						"user": meta.User(),
-					},
+					Extensions: extensions(meta, key),
 				}
 				return perm, nil
 			}
@@ -141,6 +213,16 @@ func AuthKey(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt)
 	return auth.Authenticate(meta, key)
 }
 
+// extensions returns the extensions granted to a key: the user it logged in as and the
+// fingerprints of the key, which the commands run over the connection look up.
+func extensions(meta ssh.ConnMetadata, key ssh.PublicKey) map[string]string {
+	return map[string]string{
+		"user":               meta.User(),
+		"fingerprint":        Fingerprint(key),
+		"sha256-fingerprint": FingerprintSHA256(key),
+	}
+}
+
 // compareKeys compares to key files and returns true of they match.
 func compareKeys(a, b ssh.PublicKey) bool {
 	if a.Type() != b.Type() {
@@ -169,7 +251,8 @@ func Start(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 // Configure creates a new SSH configuration object.
 //
 // Config sets a PublicKeyCallback handler that forwards public key auth
-// requests to the route named "pubkeyAuth".
+// requests to the route named "pubkeyAuth". Each request runs in its own copy of
+// the context, and the permissions it grants are kept by the connection.
 //
 // This assumes certain details about our environment, like the location of the
 // host keys. It also provides only key-based authentication.
@@ -182,31 +265,78 @@ func Configure(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrup
 
 	cfg := &ssh.ServerConfig{
 		PublicKeyCallback: func(m ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
-			c.Put("metadata", m)
-			c.Put("key", k)
+			// handshakes run concurrently, so each gets its own context
+			cxt := c.Copy()
+			cxt.Put("metadata", m)
+			cxt.Put("key", k)
 
-			pubkeyAuth := c.Get("route.sshd.pubkeyAuth", "pubkeyAuth").(string)
-			err := router.HandleRequest(pubkeyAuth, c, true)
-			return c.Get("pubkeyAuth", &ssh.Permissions{}).(*ssh.Permissions), err
+			pubkeyAuth := cxt.Get("route.sshd.pubkeyAuth", "pubkeyAuth").(string)
+			err := router.HandleRequest(pubkeyAuth, cxt, true)
+			// the permissions of the connection are the result of its "authN" command
+			return cxt.Get("authN", &ssh.Permissions{}).(*ssh.Permissions), err
 		},
 	}
 
 	return cfg, nil
 }
 
-// FingerprintKey fingerprints a key and returns the colon-formatted version
+// FingerprintKey fingerprints a key and returns the colon-formatted MD5 version, or the
+// SHA256 version shown by OpenSSH.
+//
+// The key of a certificate is fingerprinted instead of the certificate.
 //
 // Params:
 // 	- key (ssh.PublicKey): The key to fingerprint.
+// 	- hash (string): "md5" or "sha256". Defaults to "md5".
 //
 // Returns:
 // 	- A string representation of the key fingerprint.
 func FingerprintKey(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
 	key := p.Get("key", nil).(ssh.PublicKey)
-	return Fingerprint(key), nil
+	hash := p.Get("hash", "md5").(string)
+	// a certificate is fingerprinted by the key it certifies
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	switch hash {
+	case "md5":
+		return Fingerprint(key), nil
+	case "sha256":
+		return FingerprintSHA256(key), nil
+	}
+	return "", fmt.Errorf("Unknown fingerprint hash %s.", hash)
+}
+
+// Extension returns an extension of the permissions a connection was granted, such as the
+// "fingerprint" of its key.
+//
+// Params:
+// 	- permissions (*ssh.Permissions): The permissions of the connection.
+// 	- name (string): The name of the extension.
+//
+// Returns:
+// 	- The value of the extension.
+func Extension(c cookoo.Context, p *cookoo.Params) (interface{}, cookoo.Interrupt) {
+	perms, _ := p.Get("permissions", nil).(*ssh.Permissions)
+	name := p.Get("name", "").(string)
+	if perms == nil {
+		return "", fmt.Errorf("Connection has no permissions.")
+	}
+	value, ok := perms.Extensions[name]
+	if !ok {
+		return "", fmt.Errorf("Connection has no %s.", name)
+	}
+	return value, nil
+}
+
+// FingerprintSHA256 generates the SHA256 fingerprint of a public key, in the form
+// "SHA256:<unpadded base64>" shown by OpenSSH.
+func FingerprintSHA256(key ssh.PublicKey) string {
+	hash := sha256.Sum256(key.Marshal())
+	return "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(hash[:]), "=")
 }
 
-// Fingerprint generates a colon-separated fingerprint string from a public key.
+// Fingerprint generates a colon-separated MD5 fingerprint string from a public key.
 func Fingerprint(key ssh.PublicKey) string {
 	hash := md5.Sum(key.Marshal())
 	buf := make([]byte, hex.EncodedLen(len(hash)))
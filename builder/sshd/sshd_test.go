@@ -1,7 +1,12 @@
 package sshd
 
 import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/Masterminds/cookoo"
 	"golang.org/x/crypto/ssh"
@@ -54,3 +59,154 @@ func TestFingerprint(t *testing.T) {
 		t.Errorf("Expected fingerprint %s to match %s.", fp, testingClientFingerprint)
 	}
 }
+
+// authCert runs AuthKey for a certificate of the testing client key, signed by the testing
+// host key.
+func authCert(t *testing.T, cert *ssh.Certificate, authorities []ssh.PublicKey) (*ssh.Permissions, error) {
+	client, err := sshTestingClientKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.ParsePrivateKey([]byte(testingHostKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Key = client.PublicKey()
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	reg, router, cxt := cookoo.Cookoo()
+	reg.Route("auth", "Authenticate a certificate.").
+		Does(AuthKey, "auth").
+		Using("key").WithDefault(cert).
+		Using("metadata").WithDefault(&connMetadata{}).
+		Using("authorities").WithDefault(authorities)
+	err = router.HandleRequest("auth", cxt, true)
+	perms, _ := cxt.Get("auth", nil).(*ssh.Permissions)
+	return perms, err
+}
+
+func TestAuthCert(t *testing.T) {
+	ca, _ := ssh.ParsePrivateKey([]byte(testingHostKey))
+	authorities := []ssh.PublicKey{ca.PublicKey()}
+	now := time.Now().Unix()
+
+	perms, err := authCert(t, &ssh.Certificate{
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice", "git"},
+		ValidAfter:      uint64(now - 60),
+		ValidBefore:     uint64(now + 60),
+	}, authorities)
+	if err != nil {
+		t.Fatalf("Expected the certificate to be accepted, got %s", err)
+	}
+	if principal := perms.Extensions["principal"]; principal != "alice" {
+		t.Errorf("Expected the principal to be 'alice', got '%s'", principal)
+	}
+	if user := perms.Extensions["user"]; user != "deis" {
+		t.Errorf("Expected user to be 'deis', got '%s'", user)
+	}
+
+	rejected := map[string]*ssh.Certificate{
+		"expired": {
+			CertType: ssh.UserCert, ValidPrincipals: []string{"alice"},
+			ValidAfter: uint64(now - 120), ValidBefore: uint64(now - 60),
+		},
+		"not yet valid": {
+			CertType: ssh.UserCert, ValidPrincipals: []string{"alice"},
+			ValidAfter: uint64(now + 60), ValidBefore: uint64(now + 120),
+		},
+		"without principal": {
+			CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity,
+		},
+		"host": {
+			CertType: ssh.HostCert, ValidPrincipals: []string{"alice"},
+			ValidBefore: ssh.CertTimeInfinity,
+		},
+	}
+	for name, cert := range rejected {
+		if _, err := authCert(t, cert, authorities); err == nil {
+			t.Errorf("Expected the %s certificate to be rejected.", name)
+		}
+	}
+
+	valid := &ssh.Certificate{
+		CertType: ssh.UserCert, ValidPrincipals: []string{"alice"},
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	if _, err := authCert(t, valid, []ssh.PublicKey{}); err == nil {
+		t.Error("Expected a certificate from an untrusted authority to be rejected.")
+	}
+}
+
+func TestParseAuthorities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authorities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "user_ca.pub")
+
+	reg, router, cxt := cookoo.Cookoo()
+	reg.Route("parse", "Parse the authorities.").
+		Does(ParseAuthorities, "authorities").
+		Using("path").WithDefault(path)
+
+	// a missing file trusts no one
+	if err := router.HandleRequest("parse", cxt, true); err != nil {
+		t.Fatal(err)
+	}
+	if authorities := cxt.Get("authorities", nil).([]ssh.PublicKey); len(authorities) != 0 {
+		t.Errorf("Expected no authorities, got %d", len(authorities))
+	}
+
+	ca, _ := ssh.ParsePrivateKey([]byte(testingHostKey))
+	data := append(ssh.MarshalAuthorizedKey(ca.PublicKey()), []byte("# old ca\n"+testingClientPubKey+"\n")...)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.HandleRequest("parse", cxt, true); err != nil {
+		t.Fatal(err)
+	}
+	authorities := cxt.Get("authorities", nil).([]ssh.PublicKey)
+	if len(authorities) != 2 || !compareKeys(authorities[0], ca.PublicKey()) {
+		t.Errorf("Expected the two authorities, got %v", authorities)
+	}
+}
+
+func TestFingerprintSHA256(t *testing.T) {
+	key, _ := sshTestingClientKey()
+	expected := "SHA256:gZP7EU/ZdWeeeB3pWqe2oLK2Vj+Pmsb3ikVrvA6kGfw"
+	if fp := FingerprintSHA256(key.PublicKey()); fp != expected {
+		t.Errorf("Expected fingerprint %s to match %s.", fp, expected)
+	}
+
+	reg, router, cxt := cookoo.Cookoo()
+	reg.Route("fingerprint", "Fingerprint a key.").
+		Does(FingerprintKey, "fingerprint").
+		Using("key").WithDefault(key.PublicKey()).
+		Using("hash").WithDefault("sha256")
+	if err := router.HandleRequest("fingerprint", cxt, true); err != nil {
+		t.Fatal(err)
+	}
+	if fp := cxt.Get("fingerprint", "").(string); fp != expected {
+		t.Errorf("Expected fingerprint %s to match %s.", fp, expected)
+	}
+}
+
+func TestFingerprintCert(t *testing.T) {
+	key, _ := sshTestingClientKey()
+	cert := &ssh.Certificate{Key: key.PublicKey(), CertType: ssh.UserCert}
+
+	reg, router, cxt := cookoo.Cookoo()
+	reg.Route("fingerprint", "Fingerprint a certificate.").
+		Does(FingerprintKey, "fingerprint").
+		Using("key").WithDefault(cert)
+	if err := router.HandleRequest("fingerprint", cxt, true); err != nil {
+		t.Fatal(err)
+	}
+	if fp := cxt.Get("fingerprint", "").(string); fp != testingClientFingerprint {
+		t.Errorf("Expected the certificate to be fingerprinted by its key %s, got %s", testingClientFingerprint, fp)
+	}
+}
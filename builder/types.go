@@ -18,6 +18,7 @@ type ConfigHook struct {
 
 // BuildHook represents a controller's build-hook object.
 type BuildHook struct {
+	UUID        string      `json:"uuid"`
 	Sha         string      `json:"sha"`
 	ReceiveUser string      `json:"receive_user"`
 	ReceiveRepo string      `json:"receive_repo"`
@@ -89,6 +89,9 @@ func GetDefaultType(bytes []byte) (string, error) {
 }
 
 // ParseControllerConfig returns configuration key/value pair strings from a config.
+//
+// Deprecated: the values are not quoted for a shell. Builds pass the config to docker as
+// separate arguments, see build.Builder.
 func ParseControllerConfig(bytes []byte) ([]string, error) {
 	var controllerConfig Config
 	if err := json.Unmarshal(bytes, &controllerConfig); err != nil {
@@ -76,6 +76,48 @@ func BuildsCreate(appID, image, procfile string) error {
 	return nil
 }
 
+// BuildsCancel cancels the running build of an app.
+func BuildsCancel(appID string) error {
+	c, appID, err := load(appID)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cancelling the build of %s... ", appID)
+
+	if err = builds.Cancel(c, appID); err != nil {
+		return err
+	}
+
+	fmt.Println("done")
+
+	return nil
+}
+
+// BuildsLogs prints the output of a build of an app.
+func BuildsLogs(appID, uuid string) error {
+	c, appID, err := load(appID)
+
+	if err != nil {
+		return err
+	}
+
+	logs, err := builds.Logs(c, appID, uuid)
+
+	if err != nil {
+		return err
+	}
+
+	if logs == "" {
+		fmt.Printf("No logs found for build %s\n", uuid)
+		return nil
+	}
+
+	fmt.Print(logs)
+	return nil
+}
+
 func parseProcfile(procfile []byte) (map[string]string, error) {
 	procfileMap := make(map[string]string)
 	return procfileMap, yaml.Unmarshal(procfile, &procfileMap)
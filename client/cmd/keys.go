@@ -38,6 +38,10 @@ func KeysList(results int) error {
 
 	for _, key := range keys {
 		fmt.Printf("%s %s...%s\n", key.ID, key.Public[:16], key.Public[len(key.Public)-10:])
+		// older controllers do not fingerprint keys
+		if key.SHA256Fingerprint != "" {
+			fmt.Printf("  %s (MD5:%s)\n", key.SHA256Fingerprint, key.Fingerprint)
+		}
 	}
 	return nil
 }
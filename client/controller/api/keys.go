@@ -2,12 +2,14 @@ package api
 
 // Key is the definition of the key object.
 type Key struct {
-	Created string `json:"created"`
-	ID      string `json:"id"`
-	Owner   string `json:"owner"`
-	Public  string `json:"public"`
-	Updated string `json:"updated"`
-	UUID    string `json:"uuid"`
+	Created           string `json:"created"`
+	ID                string `json:"id"`
+	Owner             string `json:"owner"`
+	Public            string `json:"public"`
+	Fingerprint       string `json:"fingerprint"`
+	SHA256Fingerprint string `json:"sha256_fingerprint"`
+	Updated           string `json:"updated"`
+	UUID              string `json:"uuid"`
 }
 
 type Keys []Key
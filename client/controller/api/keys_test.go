@@ -7,10 +7,10 @@ import (
 
 func TestKeysSorted(t *testing.T) {
 	keys := Keys{
-		{"", "Delta", "", "", "", ""},
-		{"", "Alpha", "", "", "", ""},
-		{"", "Gamma", "", "", "", ""},
-		{"", "Zeta", "", "", "", ""},
+		{"", "Delta", "", "", "", "", "", ""},
+		{"", "Alpha", "", "", "", "", "", ""},
+		{"", "Gamma", "", "", "", "", "", ""},
+		{"", "Zeta", "", "", "", "", "", ""},
 	}
 
 	sort.Sort(keys)
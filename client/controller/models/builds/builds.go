@@ -52,3 +52,16 @@ func New(c *client.Client, appID string, image string,
 
 	return build, nil
 }
+
+// Cancel asks the builder to stop the running build of an app.
+func Cancel(c *client.Client, appID string) error {
+	u := fmt.Sprintf("/v1/apps/%s/builds/cancel/", appID)
+	_, err := c.BasicRequest("POST", u, nil)
+	return err
+}
+
+// Logs returns the output of a build of an app.
+func Logs(c *client.Client, appID string, uuid string) (string, error) {
+	u := fmt.Sprintf("/v1/apps/%s/builds/%s/logs/", appID, uuid)
+	return c.BasicRequest("GET", u, nil)
+}
@@ -51,6 +51,8 @@ const buildFixture string = `
     "uuid": "de1bf5b5-4a72-4f94-a10c-d2a3741cdf75"
 }`
 
+const buildLogsFixture string = "-----> Building Docker image\n-----> Launching... \n"
+
 const buildExpected string = `{"image":"deis/example-go","procfile":{"web":"example-go"}}`
 
 type fakeHTTPServer struct{}
@@ -84,6 +86,17 @@ func (fakeHTTPServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if req.URL.Path == "/v1/apps/example-go/builds/cancel/" && req.Method == "POST" {
+		res.WriteHeader(http.StatusNoContent)
+		res.Write(nil)
+		return
+	}
+
+	if req.URL.Path == "/v1/apps/example-go/builds/de1bf5b5-4a72-4f94-a10c-d2a3741cdf75/logs/" && req.Method == "GET" {
+		res.Write([]byte(buildLogsFixture))
+		return
+	}
+
 	fmt.Printf("Unrecognized URL %s\n", req.URL)
 	res.WriteHeader(http.StatusNotFound)
 	res.Write(nil)
@@ -176,3 +189,57 @@ func TestBuildCreate(t *testing.T) {
 		t.Error(fmt.Errorf("Expected %v, Got %v", expected, actual))
 	}
 }
+
+func TestBuildCancel(t *testing.T) {
+	t.Parallel()
+
+	handler := fakeHTTPServer{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient := client.CreateHTTPClient(false)
+
+	client := client.Client{HTTPClient: httpClient, ControllerURL: *u, Token: "abc"}
+
+	if err = Cancel(&client, "example-go"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Cancel(&client, "unknown-app"); err == nil {
+		t.Error("Expected an error for an unknown app")
+	}
+}
+
+func TestBuildLogs(t *testing.T) {
+	t.Parallel()
+
+	handler := fakeHTTPServer{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient := client.CreateHTTPClient(false)
+
+	client := client.Client{HTTPClient: httpClient, ControllerURL: *u, Token: "abc"}
+
+	actual, err := Logs(&client, "example-go", "de1bf5b5-4a72-4f94-a10c-d2a3741cdf75")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual != buildLogsFixture {
+		t.Errorf("Expected %q, Got %q", buildLogsFixture, actual)
+	}
+}
@@ -35,6 +35,8 @@ const keysListFixture string = `
 						"id": "test@example.com",
 						"owner": "test",
 						"public": "ssh-rsa abc test@example.com",
+						"fingerprint": "54:6d:da:1f:91:b5:2b:6f:a2:83:90:c4:f9:73:76:f5",
+						"sha256_fingerprint": "SHA256:dticnEeje35MjrAeZF1NFPuQ/Py/KX4h8GL0crzvktM",
 						"updated": "2014-01-01T00:00:00UTC",
 						"uuid": "de1bf5b5-4a72-4f94-a10c-d2a3741cdf75"
 				}
@@ -90,12 +92,14 @@ func TestKeysList(t *testing.T) {
 
 	expected := api.Keys{
 		api.Key{
-			Created: "2014-01-01T00:00:00UTC",
-			ID:      "test@example.com",
-			Owner:   "test",
-			Public:  "ssh-rsa abc test@example.com",
-			Updated: "2014-01-01T00:00:00UTC",
-			UUID:    "de1bf5b5-4a72-4f94-a10c-d2a3741cdf75",
+			Created:           "2014-01-01T00:00:00UTC",
+			ID:                "test@example.com",
+			Owner:             "test",
+			Public:            "ssh-rsa abc test@example.com",
+			Fingerprint:       "54:6d:da:1f:91:b5:2b:6f:a2:83:90:c4:f9:73:76:f5",
+			SHA256Fingerprint: "SHA256:dticnEeje35MjrAeZF1NFPuQ/Py/KX4h8GL0crzvktM",
+			Updated:           "2014-01-01T00:00:00UTC",
+			UUID:              "de1bf5b5-4a72-4f94-a10c-d2a3741cdf75",
 		},
 	}
 
@@ -12,6 +12,8 @@ Valid commands for builds:
 
 builds:list        list build history for an application
 builds:create      imports an image and deploys as a new release
+builds:cancel      stops the build of an application which is running
+builds:logs        view the output of a build
 
 Use 'deis help [command]' to learn more.
 `
@@ -21,6 +23,10 @@ Use 'deis help [command]' to learn more.
 		return buildsList(argv)
 	case "builds:create":
 		return buildsCreate(argv)
+	case "builds:cancel":
+		return buildsCancel(argv)
+	case "builds:logs":
+		return buildsLogs(argv)
 	default:
 		if printHelp(argv, usage) {
 			return nil
@@ -97,3 +103,49 @@ Options:
 
 	return cmd.BuildsCreate(app, image, procfile)
 }
+
+func buildsCancel(argv []string) error {
+	usage := `
+Stops the build of an application which is running after a 'git push'. The push
+fails and no release is created. Pushes waiting to be built are dropped too.
+
+Usage: deis builds:cancel [options]
+
+Options:
+  -a --app=<app>
+    the uniquely identifiable name for the application.
+`
+
+	args, err := docopt.Parse(usage, argv, true, "", false, true)
+
+	if err != nil {
+		return err
+	}
+
+	return cmd.BuildsCancel(safeGetValue(args, "--app"))
+}
+
+func buildsLogs(argv []string) error {
+	usage := `
+Prints the output of a build of an application, as kept by the builder. Failed and
+cancelled builds have logs too.
+
+Usage: deis builds:logs <uuid> [options]
+
+Arguments:
+  <uuid>
+    the uuid of the build, as shown by 'git push' and 'deis builds:list'.
+
+Options:
+  -a --app=<app>
+    the uniquely identifiable name for the application.
+`
+
+	args, err := docopt.Parse(usage, argv, true, "", false, true)
+
+	if err != nil {
+		return err
+	}
+
+	return cmd.BuildsLogs(safeGetValue(args, "--app"), safeGetValue(args, "<uuid>"))
+}
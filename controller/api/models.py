@@ -79,6 +79,15 @@ def validate_id_is_docker_compatible(value):
         raise ValidationError("App IDs can only contain [a-z0-9-].")
 
 
+def validate_id_has_no_double_hyphen(value):
+    """
+    Check that the ID does not contain "--", which separates the app, process type and port in
+    the hostnames the router gives to published ports
+    """
+    if '--' in value:
+        raise ValidationError('App IDs cannot contain --.')
+
+
 def validate_app_structure(value):
     """Error if the dict values aren't ints >= 0."""
     try:
@@ -175,6 +184,7 @@ class App(UuidAuditedModel):
     owner = models.ForeignKey(settings.AUTH_USER_MODEL)
     id = models.SlugField(max_length=64, unique=True, default=select_app_name,
                           validators=[validate_id_is_docker_compatible,
+                                      validate_id_has_no_double_hyphen,
                                       validate_reserved_names])
     structure = JSONField(default={}, blank=True, validators=[validate_app_structure])
 
@@ -563,6 +573,32 @@ class App(UuidAuditedModel):
             raise EnvironmentError('Error accessing deis-logger')
         return r.content
 
+    def cancel_build(self, user):
+        """Ask the builder to stop the build of this application which is running."""
+        if not _etcd_client:
+            raise EnvironmentError('Cannot cancel the build: no etcd client available')
+        # the builder only reacts to the key being set, the TTL just cleans it up
+        _etcd_client.write('/deis/builder/cancel/{}'.format(self.id), user.username, ttl=60)
+        log_event(self, "{} cancels the running build".format(user.username))
+
+    def build_logs(self, uuid):
+        """Return the output of a build of this application, as shipped to the logger by the
+        builder under the builder.<uuid> process.
+
+        Only the last BUILD_LOG_LINES lines of the application's logs are searched, so the log
+        of an older build is no longer available."""
+        tag = " {}[builder.{}]: ".format(self.id, uuid)
+        try:
+            logs = self.logs(str(settings.BUILD_LOG_LINES))
+        except EnvironmentError as e:
+            if e.message == 'Could not locate logs':
+                raise EnvironmentError('Build log no longer available')
+            raise
+        lines = [line.split(tag, 1)[1] for line in logs.splitlines() if tag in line]
+        if not lines:
+            raise EnvironmentError('Build log no longer available')
+        return "\n".join(lines) + "\n"
+
     def run(self, user, command):
         """Run a one-off command in an ephemeral app container."""
         # FIXME: remove the need for SSH private keys by using
@@ -850,6 +886,14 @@ class Config(UuidAuditedModel):
             pass
         return super(Config, self).save(**kwargs)
 
+    def runtime_values(self):
+        """
+        Return the values set in the environment of the application's containers,
+        leaving out the build-time secrets listed in DEIS_BUILD_SECRETS.
+        """
+        secrets = re.split(r'[\s,]+', self.values.get('DEIS_BUILD_SECRETS', ''))
+        return {k: v for k, v in self.values.viewitems() if k not in secrets}
+
 
 @python_2_unicode_compatible
 class Release(UuidAuditedModel):
@@ -909,7 +953,7 @@ class Release(UuidAuditedModel):
             source_image = "{}:{}".format(source_image, source_tag)
         # If the build has a SHA, assume it's from deis-builder and in the deis-registry already
         deis_registry = bool(self.build.sha)
-        publish_release(source_image, self.config.values, self.image, deis_registry)
+        publish_release(source_image, self.config.runtime_values(), self.image, deis_registry)
 
     def previous(self):
         """
@@ -13,7 +13,7 @@ from django.utils import timezone
 from rest_framework import serializers
 from rest_framework.validators import UniqueTogetherValidator
 
-from api import models
+from api import models, utils
 
 
 PROCTYPE_MATCH = re.compile(r'^(?P<type>[a-z]+)')
@@ -266,6 +266,7 @@ class KeySerializer(ModelSerializer):
 
     owner = serializers.ReadOnlyField(source='owner.username')
     fingerprint = serializers.CharField(read_only=True)
+    sha256_fingerprint = serializers.SerializerMethodField()
     created = serializers.DateTimeField(format=settings.DEIS_DATETIME_FORMAT, read_only=True)
     updated = serializers.DateTimeField(format=settings.DEIS_DATETIME_FORMAT, read_only=True)
 
@@ -273,6 +274,9 @@ class KeySerializer(ModelSerializer):
         """Metadata options for a KeySerializer."""
         model = models.Key
 
+    def get_sha256_fingerprint(self, obj):
+        return utils.fingerprint_sha256(obj.public)
+
 
 class DomainSerializer(ModelSerializer):
     """Serialize a :class:`~api.models.Domain` model."""
@@ -161,6 +161,11 @@ class AppTest(TestCase):
         response = self.client.post(url, json.dumps(body), content_type='application/json',
                                     HTTP_AUTHORIZATION='token {}'.format(self.token))
         self.assertContains(response, 'App IDs can only contain [a-z0-9-]', status_code=400)
+        # "--" is reserved for the hostnames of published ports
+        body = {'id': 'go--web--5000'}
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertContains(response, 'App IDs cannot contain --.', status_code=400)
         url = '/v1/apps'
         body = {'id': app_id}
         response = self.client.post(url, json.dumps(body), content_type='application/json',
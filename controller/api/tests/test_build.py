@@ -11,6 +11,7 @@ import json
 from django.contrib.auth.models import User
 from django.test import TransactionTestCase
 import mock
+import requests
 from rest_framework.authtoken.models import Token
 
 from api.models import Build
@@ -292,3 +293,79 @@ class BuildTest(TransactionTestCase):
                                    HTTP_AUTHORIZATION='token {}'.format(self.token))
         self.assertEqual(response.status_code, 200)
         self.assertEqual(len(response.data['results']), 0)
+
+    @mock.patch('requests.get')
+    def test_build_logs(self, mock_get):
+        """
+        The logs of a build are its lines shipped to the logger by the builder.
+        """
+        url = '/v1/apps'
+        response = self.client.post(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 201)
+        app_id = response.data['id']
+        build_uuid = '0f5ab2b4-1e0e-4f3c-9b42-8d7a8d2c3f10'
+        mock_response = mock.Mock()
+        mock_response.status_code = 200
+        mock_response.content = (
+            "2015-01-01T00:00:00UTC {app_id}[builder.{build_uuid}]: -----> Building\n"
+            "2015-01-01T00:00:01UTC {app_id}[web.1]: listening on 5000\n"
+            "2015-01-01T00:00:02UTC {app_id}[builder.{build_uuid}]: -----> Launching...\n"
+        ).format(**locals())
+        mock_get.return_value = mock_response
+        url = "/v1/apps/{app_id}/builds/{build_uuid}/logs".format(**locals())
+        response = self.client.get(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 200)
+        self.assertEqual(response.content, "-----> Building\n-----> Launching...\n")
+        # a build whose log is no longer in the logger
+        other_uuid = 'a7b6c5d4-0000-4000-8000-000000000000'
+        url = "/v1/apps/{app_id}/builds/{other_uuid}/logs".format(**locals())
+        response = self.client.get(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 404)
+        self.assertEqual(response.content,
+                         "The log of build {} is no longer available".format(other_uuid))
+        # the logger has no logs left for the application
+        mock_response.status_code = 204
+        response = self.client.get(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 404)
+        # the logger cannot be reached
+        mock_get.side_effect = requests.exceptions.RequestException('Boom!')
+        response = self.client.get(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 500)
+        # an unauthorized user cannot read the logs
+        unauthorized_user = User.objects.get(username='autotest2')
+        unauthorized_token = Token.objects.get(user=unauthorized_user).key
+        response = self.client.get(url,
+                                   HTTP_AUTHORIZATION='token {}'.format(unauthorized_token))
+        self.assertEqual(response.status_code, 403)
+
+    @mock.patch('api.models._etcd_client')
+    def test_cancel_build(self, mock_etcd):
+        """
+        Cancelling a build asks the builder to stop it through etcd.
+        """
+        url = '/v1/apps'
+        response = self.client.post(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 201)
+        app_id = response.data['id']
+        url = "/v1/apps/{app_id}/builds/cancel".format(**locals())
+        response = self.client.post(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 204)
+        mock_etcd.write.assert_called_once_with(
+            '/deis/builder/cancel/{}'.format(app_id), 'autotest', ttl=60)
+        # an unauthorized user cannot cancel the build
+        unauthorized_user = User.objects.get(username='autotest2')
+        unauthorized_token = Token.objects.get(user=unauthorized_user).key
+        response = self.client.post(url,
+                                    HTTP_AUTHORIZATION='token {}'.format(unauthorized_token))
+        self.assertEqual(response.status_code, 403)
+        self.assertEqual(mock_etcd.write.call_count, 1)
+
+    @mock.patch('api.models._etcd_client', None)
+    def test_cancel_build_without_etcd(self):
+        url = '/v1/apps'
+        response = self.client.post(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 201)
+        app_id = response.data['id']
+        url = "/v1/apps/{app_id}/builds/cancel".format(**locals())
+        response = self.client.post(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 503)
@@ -748,3 +748,16 @@ seconds".format(self.app.id)
         self.assertEqual(
             response.data,
             {'detail': 'aborting, app containers failed to respond to health check'})
+
+    def test_config_build_secrets(self):
+        """
+        Test that the build-time secrets listed in DEIS_BUILD_SECRETS are left out of the
+        values the application's containers run with.
+        """
+        values = {'DEIS_BUILD_SECRETS': 'NPM_TOKEN, GITHUB_TOKEN',
+                  'NPM_TOKEN': 'secret', 'GITHUB_TOKEN': 'secret', 'PORT': '5000'}
+        config = Config.objects.create(owner=self.user, app=self.app, values=values)
+        self.assertEqual(config.runtime_values(),
+                         {'DEIS_BUILD_SECRETS': 'NPM_TOKEN, GITHUB_TOKEN', 'PORT': '5000'})
+        # the build still gets every value
+        self.assertEqual(config.values, values)
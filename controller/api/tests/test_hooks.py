@@ -115,6 +115,32 @@ class HookTest(TransactionTestCase):
         self.assertIn('version', response.data['release'])
         self.assertIn('domains', response.data)
 
+    def test_build_hook_uuid(self):
+        """Test the builder names the Build it creates via an API Hook"""
+        url = '/v1/apps'
+        response = self.client.post(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 201)
+        app_id = response.data['id']
+        url = '/v1/hooks/builds'
+        build_uuid = '0f5ab2b4-1e0e-4f3c-9b42-8d7a8d2c3f10'
+        body = {'receive_user': 'autotest',
+                'receive_repo': app_id,
+                'image': '{app_id}:v2'.format(**locals()),
+                'uuid': build_uuid}
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_X_DEIS_BUILDER_AUTH=settings.BUILDER_KEY)
+        self.assertEqual(response.status_code, 200)
+        url = "/v1/apps/{app_id}/builds/{build_uuid}".format(**locals())
+        response = self.client.get(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 200)
+        self.assertEqual(response.data['uuid'], build_uuid)
+        # an invalid uuid is rejected
+        url = '/v1/hooks/builds'
+        body['uuid'] = 'not-a-uuid'
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_X_DEIS_BUILDER_AUTH=settings.BUILDER_KEY)
+        self.assertEqual(response.status_code, 400)
+
     def test_build_hook_procfile(self):
         """Test creating a Procfile build via an API Hook"""
         url = '/v1/apps'
@@ -228,6 +254,43 @@ class HookTest(TransactionTestCase):
         self.assertIn('values', response.data)
         self.assertEqual(values, response.data['values'])
 
+    def test_fetch_hook(self):
+        """Test authorizing a git clone via an API Hook"""
+        url = '/v1/apps'
+        response = self.client.post(url, HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 201)
+        app_id = response.data['id']
+        url = '/v1/hooks/fetch'
+        body = {'receive_user': 'autotest',
+                'receive_repo': app_id}
+        # post without an auth token
+        response = self.client.post(url, json.dumps(body), content_type='application/json')
+        self.assertEqual(response.status_code, 401)
+        # post with the builder auth key
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_X_DEIS_BUILDER_AUTH=settings.BUILDER_KEY)
+        self.assertEqual(response.status_code, 204)
+        # a user who is not a collaborator cannot fetch
+        body['receive_user'] = 'autotest2'
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_X_DEIS_BUILDER_AUTH=settings.BUILDER_KEY)
+        self.assertEqual(response.status_code, 403)
+        # unless the app is shared with them
+        url = '/v1/apps/{app_id}/perms'.format(**locals())
+        response = self.client.post(url, json.dumps({'username': 'autotest2'}),
+                                    content_type='application/json',
+                                    HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 201)
+        url = '/v1/hooks/fetch'
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_X_DEIS_BUILDER_AUTH=settings.BUILDER_KEY)
+        self.assertEqual(response.status_code, 204)
+        # an unknown app
+        body['receive_repo'] = 'unknown'
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_X_DEIS_BUILDER_AUTH=settings.BUILDER_KEY)
+        self.assertEqual(response.status_code, 404)
+
     def test_admin_can_hook(self):
         """Administrator should be able to create build hooks on non-admin apps.
         """
@@ -14,7 +14,7 @@ from django.test import TestCase
 from rest_framework.authtoken.models import Token
 
 from api.models import Key
-from api.utils import fingerprint
+from api.utils import fingerprint, fingerprint_sha256
 
 
 RSA_PUBKEY = (
@@ -153,6 +153,24 @@ class KeyTest(TestCase):
         fp = fingerprint(RSA_PUBKEY)
         self.assertEquals(fp, '54:6d:da:1f:91:b5:2b:6f:a2:83:90:c4:f9:73:76:f5')
 
+    def test_rsa_key_fingerprint_sha256(self):
+        fp = fingerprint_sha256(RSA_PUBKEY)
+        self.assertEquals(fp, 'SHA256:dticnEeje35MjrAeZF1NFPuQ/Py/KX4h8GL0crzvktM')
+
+    def test_key_fingerprints(self):
+        """
+        Test that both fingerprints of a key are shown, so users can match either of them
+        """
+        url = '/v1/keys'
+        body = {'id': 'mykey@box.local', 'public': RSA_PUBKEY}
+        response = self.client.post(url, json.dumps(body), content_type='application/json',
+                                    HTTP_AUTHORIZATION='token {}'.format(self.token))
+        self.assertEqual(response.status_code, 201)
+        self.assertEqual(response.data['fingerprint'],
+                         '54:6d:da:1f:91:b5:2b:6f:a2:83:90:c4:f9:73:76:f5')
+        self.assertEqual(response.data['sha256_fingerprint'],
+                         'SHA256:dticnEeje35MjrAeZF1NFPuQ/Py/KX4h8GL0crzvktM')
+
     def test_key_api_with_non_superuser_rsa(self):
         self.user = User.objects.get(username='autotest2')
         self.token = self.user.auth_token.key
@@ -19,6 +19,10 @@ urlpatterns = patterns(
     # application release components
     url(r"^apps/(?P<id>{})/config/?".format(settings.APP_URL_REGEX),
         views.ConfigViewSet.as_view({'get': 'retrieve', 'post': 'create'})),
+    url(r"^apps/(?P<id>{})/builds/cancel/?".format(settings.APP_URL_REGEX),
+        views.BuildViewSet.as_view({'post': 'cancel'})),
+    url(r"^apps/(?P<id>{})/builds/(?P<uuid>[-_\w]+)/logs/?".format(settings.APP_URL_REGEX),
+        views.BuildViewSet.as_view({'get': 'logs'})),
     url(r"^apps/(?P<id>{})/builds/(?P<uuid>[-_\w]+)/?".format(settings.APP_URL_REGEX),
         views.BuildViewSet.as_view({'get': 'retrieve'})),
     url(r"^apps/(?P<id>{})/builds/?".format(settings.APP_URL_REGEX),
@@ -78,6 +82,8 @@ urlpatterns = patterns(
         views.BuildHookViewSet.as_view({'post': 'create'})),
     url(r'^hooks/config/?',
         views.ConfigHookViewSet.as_view({'post': 'create'})),
+    url(r'^hooks/fetch/?',
+        views.FetchHookViewSet.as_view({'post': 'create'})),
     # authn / authz
     url(r'^auth/register/?',
         views.UserRegistrationViewSet.as_view({'post': 'create'})),
@@ -101,13 +101,21 @@ def dict_diff(dict1, dict2):
 
 def fingerprint(key):
     """
-    Return the fingerprint for an SSH Public Key
+    Return the MD5 fingerprint for an SSH Public Key
     """
     key = base64.b64decode(key.strip().split()[1].encode('ascii'))
     fp_plain = hashlib.md5(key).hexdigest()
     return ':'.join(a + b for a, b in zip(fp_plain[::2], fp_plain[1::2]))
 
 
+def fingerprint_sha256(key):
+    """
+    Return the SHA256 fingerprint for an SSH Public Key, as shown by OpenSSH
+    """
+    key = base64.b64decode(key.strip().split()[1].encode('ascii'))
+    return 'SHA256:' + base64.b64encode(hashlib.sha256(key).digest()).rstrip('=')
+
+
 def encode(obj):
     """Return UTF-8 encoding for string objects."""
     if isinstance(obj, basestring):
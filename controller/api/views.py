@@ -4,6 +4,7 @@ RESTful view classes for presenting Deis API objects.
 
 import os.path
 import tempfile
+import uuid
 
 from django.conf import settings
 from django.core.exceptions import ValidationError
@@ -276,6 +277,34 @@ class BuildViewSet(ReleasableViewSet):
         self.release = build.create(self.request.user)
         super(BuildViewSet, self).post_save(build)
 
+    def logs(self, request, **kwargs):
+        app = self.get_app()
+        try:
+            return HttpResponse(app.build_logs(kwargs['uuid']),
+                                status=status.HTTP_200_OK, content_type='text/plain')
+        except requests.exceptions.RequestException:
+            return HttpResponse("Error accessing logs for {}".format(app.id),
+                                status=status.HTTP_500_INTERNAL_SERVER_ERROR,
+                                content_type='text/plain')
+        except EnvironmentError as e:
+            if e.message == 'Error accessing deis-logger':
+                return HttpResponse("Error accessing logs for {}".format(app.id),
+                                    status=status.HTTP_500_INTERNAL_SERVER_ERROR,
+                                    content_type='text/plain')
+            else:
+                return HttpResponse("The log of build {} is no longer available"
+                                    .format(kwargs['uuid']),
+                                    status=status.HTTP_404_NOT_FOUND,
+                                    content_type='text/plain')
+
+    def cancel(self, request, **kwargs):
+        app = self.get_app()
+        try:
+            app.cancel_build(request.user)
+        except EnvironmentError as e:
+            return Response({'detail': str(e)}, status=status.HTTP_503_SERVICE_UNAVAILABLE)
+        return Response(status=status.HTTP_204_NO_CONTENT)
+
 
 class ConfigViewSet(ReleasableViewSet):
     """A viewset for interacting with Config objects."""
@@ -422,12 +451,25 @@ class BuildHookViewSet(BaseHookViewSet):
             raise PermissionDenied()
         request.data['app'] = app
         request.data['owner'] = self.user
+        # the builder names its builds, so that their logs can be found by the same uuid
+        if request.data.get('uuid'):
+            try:
+                self.uuid = str(uuid.UUID(request.data['uuid']))
+            except ValueError:
+                return Response({'detail': 'Invalid build uuid'},
+                                status=status.HTTP_400_BAD_REQUEST)
         super(BuildHookViewSet, self).create(request, *args, **kwargs)
         # return the application databag
         response = {'release': {'version': app.release_set.latest().version},
                     'domains': ['.'.join([app.id, settings.DEIS_DOMAIN])]}
         return Response(response, status=status.HTTP_200_OK)
 
+    def perform_create(self, serializer):
+        if hasattr(self, 'uuid'):
+            self.post_save(serializer.save(owner=self.request.user, uuid=self.uuid))
+        else:
+            super(BuildHookViewSet, self).perform_create(serializer)
+
     def post_save(self, build):
         build.create(self.user)
 
@@ -448,6 +490,20 @@ class ConfigHookViewSet(BaseHookViewSet):
         return Response(serializer.data, status=status.HTTP_200_OK)
 
 
+class FetchHookViewSet(BaseHookViewSet):
+    """API hook to authorize a git clone or fetch of an :class:`~api.models.App`"""
+    model = models.App
+    serializer_class = serializers.AppSerializer
+
+    def create(self, request, *args, **kwargs):
+        app = get_object_or_404(models.App, id=request.data['receive_repo'])
+        request.user = get_object_or_404(User, username=request.data['receive_user'])
+        # fetching is read-only, so it is allowed to the same users as a push
+        if not permissions.is_app_user(request, app):
+            raise PermissionDenied()
+        return Response(status=status.HTTP_204_NO_CONTENT)
+
+
 class AppPermsViewSet(BaseDeisViewSet):
     """RESTful views for sharing apps with collaborators."""
 
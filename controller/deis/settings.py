@@ -288,6 +288,8 @@ ETCD_HOST, ETCD_PORT = os.environ.get('ETCD', '127.0.0.1:4001').split(',')[0].sp
 
 # default deis settings
 LOG_LINES = 1000
+# how far back in an application's logs to look for the log of a build
+BUILD_LOG_LINES = 10000
 TEMPDIR = tempfile.mkdtemp(prefix='deis')
 DEIS_DOMAIN = 'deisapp.local'
 
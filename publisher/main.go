@@ -16,20 +16,26 @@ import (
 const (
 	defaultRefreshTime time.Duration = 10 * time.Second
 	defaultEtcdTTL     time.Duration = defaultRefreshTime * 2
+	defaultDrainTime   time.Duration = 0
 	defaultHost                      = "127.0.0.1"
 	defaultDockerHost                = "unix:///var/run/docker.sock"
 	defaultEtcdHost                  = "127.0.0.1"
 	defaultEtcdPort                  = "4001"
+	defaultRegistry                  = "etcd"
+	defaultConsulAddr                = "127.0.0.1:8500"
 	defaultLogLevel                  = "error"
 )
 
 var (
 	refreshDuration = flag.Duration("refresh-duration", defaultRefreshTime, "The time to wait between etcd refreshes.")
 	etcdTTL         = flag.Duration("etcd-ttl", defaultEtcdTTL, "The TTL for all of the keys in etcd.")
+	drainDuration   = flag.Duration("drain-duration", defaultDrainTime, "The time a stopped container is marked as draining before it is unpublished. Zero unpublishes it right away.")
 	host            = flag.String("host", defaultHost, "The host where the publisher is running.")
 	dockerHost      = flag.String("docker-host", defaultDockerHost, "The host where to find docker.")
 	etcdHost        = flag.String("etcd-host", defaultEtcdHost, "The etcd host.")
 	etcdPort        = flag.String("etcd-port", defaultEtcdPort, "The etcd port.")
+	registry        = flag.String("registry", defaultRegistry, "The registry to publish containers to. Acceptable values: etcd, consul")
+	consulAddr      = flag.String("consul-addr", defaultConsulAddr, "The address of the consul agent, when publishing to consul.")
 	logLevel        = flag.String("log-level", defaultLogLevel, "Acceptable values: error, debug")
 )
 
@@ -41,10 +47,22 @@ func main() {
 		log.Fatal(err)
 	}
 	etcdClient := etcd.NewClient([]string{"http://" + *etcdHost + ":" + *etcdPort})
+	config := server.NewEtcdRegistry(etcdClient)
 
-	server := server.New(dockerClient, etcdClient, *host, *logLevel)
+	var reg server.Registry
+	switch *registry {
+	case "etcd":
+		reg = config
+	case "consul":
+		reg = server.NewConsulRegistry(*consulAddr)
+	default:
+		log.Fatalf("unknown registry %q", *registry)
+	}
+
+	server := server.New(dockerClient, config, reg, *host, *logLevel)
 
-	go server.Listen(*etcdTTL)
+	go server.Webhook.Run()
+	go server.Listen(*etcdTTL, *drainDuration)
 
 	go func() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
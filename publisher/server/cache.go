@@ -0,0 +1,138 @@
+package server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// containerInfo is what the publisher knows about a published container.
+type containerInfo struct {
+	Name     string
+	App      string
+	ProcType string
+	Version  int
+	// Keys maps the registry keys published for the container to their host:port values.
+	Keys map[string]string
+	// Published is true while the container's keys are published.
+	Published bool
+}
+
+// backend returns the host:port the container is routed to by its application's hostname,
+// or any of its published ports for other process types.
+func (info *containerInfo) backend() string {
+	var backend string
+	for key, value := range info.Keys {
+		if strings.HasPrefix(key, "/deis/services/") {
+			return value
+		}
+		backend = value
+	}
+	return backend
+}
+
+// containerCache holds the containers published by this publisher, by container ID.
+var containerCache = struct {
+	sync.RWMutex
+	data map[string]*containerInfo
+}{data: make(map[string]*containerInfo)}
+
+// appVersions holds the latest version of each application published to the registry, as of
+// the last poll.
+var appVersions = struct {
+	sync.RWMutex
+	data map[string]int
+}{data: make(map[string]int)}
+
+// cacheContainer stores a container's info in the cache and returns its previous info, if any.
+func cacheContainer(id string, info *containerInfo) *containerInfo {
+	containerCache.Lock()
+	defer containerCache.Unlock()
+	prev := containerCache.data[id]
+	containerCache.data[id] = info
+	return prev
+}
+
+// setPublished marks a cached container as published or not, returning its info.
+func setPublished(id string, published bool) *containerInfo {
+	containerCache.Lock()
+	defer containerCache.Unlock()
+	info, ok := containerCache.data[id]
+	if !ok {
+		return nil
+	}
+	info.Published = published
+	updated := *info
+	return &updated
+}
+
+// cachedContainer returns a container's info from the cache, or nil if it is not cached.
+func cachedContainer(id string) *containerInfo {
+	containerCache.RLock()
+	defer containerCache.RUnlock()
+	return containerCache.data[id]
+}
+
+// uncacheContainer removes a container from the cache and returns its info, if any.
+func uncacheContainer(id string) *containerInfo {
+	containerCache.Lock()
+	defer containerCache.Unlock()
+	info := containerCache.data[id]
+	delete(containerCache.data, id)
+	return info
+}
+
+// cachedContainerIDs returns the IDs of all cached containers.
+func cachedContainerIDs() []string {
+	containerCache.RLock()
+	defer containerCache.RUnlock()
+	ids := make([]string, 0, len(containerCache.data))
+	for id := range containerCache.data {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// updateAppVersions replaces the latest published version of every application using the
+// keys found under /deis/services.
+func updateAppVersions(services map[string]string) {
+	r := regexp.MustCompile(appNameRegex)
+	versions := make(map[string]int)
+	for key := range services {
+		match := r.FindStringSubmatch(key)
+		// account for keys that may not be an application container
+		if match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		if version > versions[match[1]] {
+			versions[match[1]] = version
+		}
+	}
+	appVersions.Lock()
+	appVersions.data = versions
+	appVersions.Unlock()
+}
+
+// latestRunningVersion retrieves the highest version of the application published to the
+// registry, either as of the last poll or by this publisher since. If no app has been
+// published, returns 0.
+func latestRunningVersion(appName string) int {
+	appVersions.RLock()
+	versions := []int{appVersions.data[appName]}
+	appVersions.RUnlock()
+
+	containerCache.RLock()
+	for id, info := range containerCache.data {
+		// only healthy containers of the routable types are published under /deis/services
+		if info.App == appName && isDefaultRoutableType(info.ProcType) && isHealthy(id) {
+			versions = append(versions, info.Version)
+		}
+	}
+	containerCache.RUnlock()
+	return max(versions)
+}
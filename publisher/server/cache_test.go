@@ -0,0 +1,52 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestUpdateAppVersions(t *testing.T) {
+	updateAppVersions(map[string]string{
+		"/deis/services/go/go_v2.web.1":     "10.0.0.1:49153",
+		"/deis/services/go/go_v3.web.1":     "10.0.0.2:49153",
+		"/deis/services/ruby/ruby_v1.web.1": "10.0.0.1:49154",
+		"/deis/services/ruby/not-a-backend": "10.0.0.1:49155",
+	})
+	defer updateAppVersions(map[string]string{})
+
+	if v := latestRunningVersion("go"); v != 3 {
+		t.Errorf("expected go to be at v3, got v%d", v)
+	}
+	if v := latestRunningVersion("ruby"); v != 1 {
+		t.Errorf("expected ruby to be at v1, got v%d", v)
+	}
+	if v := latestRunningVersion("python"); v != 0 {
+		t.Errorf("expected python to be unpublished, got v%d", v)
+	}
+}
+
+func TestLatestRunningVersionFromCache(t *testing.T) {
+	cacheContainer("healthy", &containerInfo{App: "cached", ProcType: "web", Version: 5})
+	defer uncacheContainer("healthy")
+	cacheContainer("unhealthy", &containerInfo{App: "cached", ProcType: "web", Version: 6})
+	defer uncacheContainer("unhealthy")
+	cacheContainer("worker", &containerInfo{App: "cached", ProcType: "api", Version: 7})
+	defer uncacheContainer("worker")
+
+	healthMap.Lock()
+	healthMap.data["healthy"] = &healthState{healthy: true}
+	healthMap.data["worker"] = &healthState{healthy: true}
+	healthMap.Unlock()
+	defer forgetHealth("healthy")
+	defer forgetHealth("worker")
+
+	if v := latestRunningVersion("cached"); v != 5 {
+		t.Errorf("expected the latest healthy web container at v5, got v%d", v)
+	}
+
+	if info := uncacheContainer("healthy"); info == nil || info.Version != 5 {
+		t.Errorf("expected to uncache the v5 container, got %v", info)
+	}
+	if cachedContainer("healthy") != nil {
+		t.Errorf("container should no longer be cached")
+	}
+}
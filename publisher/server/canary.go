@@ -0,0 +1,23 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// canaryWeight returns the percentage of an application's traffic its newest release receives
+// while the containers of older releases stay published, as configured by canary_weight. It
+// returns 0 if the application is not being canaried.
+func (s *Server) canaryWeight(appName string) int {
+	value := s.getConfig(fmt.Sprintf("/deis/config/%s/canary_weight", appName))
+	if value == "" {
+		return 0
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 1 || weight > 99 {
+		log.Printf("ignoring canary_weight %q of %s, it must be between 1 and 99\n", value, appName)
+		return 0
+	}
+	return weight
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minConsulSessionTTL is the lowest session TTL accepted by consul.
+const minConsulSessionTTL = 10 * time.Second
+
+// ConsulRegistry is a Registry backed by the consul key/value store.
+//
+// Consul keys have no TTL of their own, so the keys set with a TTL are held by a consul
+// session of the publisher, created with the TTL of the first of them. Setting a key renews
+// the session, and consul deletes the keys once the session expires, e.g. because the
+// publisher stopped. Keys the publisher no longer refreshes are deleted by it instead.
+type ConsulRegistry struct {
+	// Address is the address of the consul agent's HTTP API, e.g. 127.0.0.1:8500.
+	Address string
+	Client  *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+	ttl       time.Duration
+	renewed   time.Time
+}
+
+// NewConsulRegistry returns a Registry backed by the consul agent at address.
+func NewConsulRegistry(address string) *ConsulRegistry {
+	return &ConsulRegistry{
+		Address: address,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Get returns the value of key, or an empty string if the key does not exist.
+func (r *ConsulRegistry) Get(key string) (string, error) {
+	resp, err := r.do("GET", r.kvURL(key, url.Values{"raw": {""}}), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	value, err := ioutil.ReadAll(resp.Body)
+	return string(value), err
+}
+
+// Set sets the value of key. The key expires after ttl, unless ttl is zero.
+func (r *ConsulRegistry) Set(key, value string, ttl time.Duration) error {
+	query := url.Values{}
+	if ttl > 0 {
+		session, err := r.session(ttl)
+		if err != nil {
+			return err
+		}
+		query.Set("acquire", session)
+	}
+	resp, err := r.do("PUT", r.kvURL(key, query), strings.NewReader(value))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var ok bool
+	if err := json.NewDecoder(resp.Body).Decode(&ok); err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("consul refused to set %s", key)
+	}
+	return nil
+}
+
+// Delete removes key.
+func (r *ConsulRegistry) Delete(key string) error {
+	resp, err := r.do("DELETE", r.kvURL(key, nil), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List recursively retrieves all keys under prefix with their values.
+func (r *ConsulRegistry) List(prefix string) (map[string]string, error) {
+	keys := make(map[string]string)
+	resp, err := r.do("GET", r.kvURL(prefix, url.Values{"recurse": {""}}), nil)
+	if err != nil {
+		return keys, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return keys, nil
+	}
+	var pairs []struct {
+		Key   string
+		Value []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return keys, err
+	}
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") {
+			// a folder
+			continue
+		}
+		keys["/"+pair.Key] = string(pair.Value)
+	}
+	return keys, nil
+}
+
+// session returns the session of the publisher, renewing it at most every half of its TTL,
+// or creates a new one with ttl.
+func (r *ConsulRegistry) session(ttl time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessionID != "" {
+		if time.Since(r.renewed) < r.ttl/2 {
+			return r.sessionID, nil
+		}
+		resp, err := r.do("PUT", r.url("/v1/session/renew/"+r.sessionID, nil), nil)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			r.renewed = time.Now()
+			return r.sessionID, nil
+		}
+		// the session expired, create a new one
+	}
+
+	if ttl < minConsulSessionTTL {
+		ttl = minConsulSessionTTL
+	}
+	body, err := json.Marshal(map[string]string{
+		"Name":      "deis-publisher",
+		"TTL":       ttl.String(),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.do("PUT", r.url("/v1/session/create", nil), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var created struct {
+		ID string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+
+	r.sessionID, r.ttl, r.renewed = created.ID, ttl, time.Now()
+	return created.ID, nil
+}
+
+func (r *ConsulRegistry) do(method, rawURL string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("consul: %s %s: %s (%s)", method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// kvURL returns the URL of a key in the key/value store. Consul keys have no leading slash.
+func (r *ConsulRegistry) kvURL(key string, query url.Values) string {
+	return r.url("/v1/kv/"+strings.TrimPrefix(key, "/"), query)
+}
+
+func (r *ConsulRegistry) url(path string, query url.Values) string {
+	u := url.URL{Scheme: "http", Host: r.Address, Path: path}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
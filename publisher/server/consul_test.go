@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConsul implements the parts of the consul HTTP API used by ConsulRegistry.
+type fakeConsul struct {
+	sync.Mutex
+	kv       map[string]string
+	holders  map[string]string
+	sessions map[string]string
+	renewed  int
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		kv:       make(map[string]string),
+		holders:  make(map[string]string),
+		sessions: make(map[string]string),
+	}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		id := fmt.Sprintf("session-%d", len(f.sessions)+1)
+		f.sessions[id] = body["TTL"]
+		fmt.Fprintf(w, `{"ID":%q}`, id)
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+		if _, ok := f.sessions[id]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f.renewed++
+		fmt.Fprint(w, "[]")
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		switch r.Method {
+		case "PUT":
+			if session := r.URL.Query().Get("acquire"); session != "" {
+				if holder, ok := f.holders[key]; ok && holder != session {
+					fmt.Fprint(w, "false")
+					return
+				}
+				f.holders[key] = session
+			}
+			value, _ := ioutil.ReadAll(r.Body)
+			f.kv[key] = string(value)
+			fmt.Fprint(w, "true")
+		case "DELETE":
+			delete(f.kv, key)
+			delete(f.holders, key)
+			fmt.Fprint(w, "true")
+		case "GET":
+			if _, ok := r.URL.Query()["recurse"]; ok {
+				var pairs []map[string]interface{}
+				for k, v := range f.kv {
+					if strings.HasPrefix(k, key) {
+						pairs = append(pairs, map[string]interface{}{"Key": k, "Value": []byte(v)})
+					}
+				}
+				if len(pairs) == 0 {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(pairs)
+				return
+			}
+			value, ok := f.kv[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, value)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConsulRegistry(t *testing.T) {
+	fake := newFakeConsul()
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	r := NewConsulRegistry(strings.TrimPrefix(ts.URL, "http://"))
+
+	key := "/deis/services/go/go_v2.web.1"
+	if err := r.Set(key, "10.0.0.1:49153", 20*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := r.Get(key); err != nil || value != "10.0.0.1:49153" {
+		t.Errorf("expected 10.0.0.1:49153, got %q (%v)", value, err)
+	}
+	if fake.kv["deis/services/go/go_v2.web.1"] == "" {
+		t.Errorf("consul keys should not have a leading slash")
+	}
+	if fake.sessions["session-1"] != "20s" {
+		t.Errorf("expected a session with a 20s TTL, got %v", fake.sessions)
+	}
+
+	// other keys are held by the same session
+	if err := r.Set("/deis/services/go/go_v2.web.2", "10.0.0.1:49154", 20*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if fake.renewed != 0 || len(fake.sessions) != 1 || fake.holders["deis/services/go/go_v2.web.2"] != "session-1" {
+		t.Errorf("expected a single session, got %d renewals of %v", fake.renewed, fake.sessions)
+	}
+
+	// setting a key renews the session once half its TTL passed
+	r.renewed = time.Now().Add(-15 * time.Second)
+	if err := r.Set(key, "10.0.0.1:49153", 20*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if fake.renewed != 1 || len(fake.sessions) != 1 {
+		t.Errorf("expected the session to be renewed, got %d renewals of %v", fake.renewed, fake.sessions)
+	}
+
+	// keys without a TTL are not held by a session
+	if err := r.Set("/deis/services/go/static", "10.0.0.1:80", 0); err != nil {
+		t.Fatal(err)
+	}
+	if fake.holders["deis/services/go/static"] != "" {
+		t.Errorf("expected the key not to be held by a session, got %v", fake.holders)
+	}
+
+	keys, err := r.List("/deis/services/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[key] != "10.0.0.1:49153" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	if err := r.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := r.Get(key); err != nil || value != "" {
+		t.Errorf("expected deleted key to be empty, got %q (%v)", value, err)
+	}
+	if keys, err := r.List("/deis/services/ruby"); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys, got %v (%v)", keys, err)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// drainMap holds the registry keys which are draining, so they are not removed as stale keys
+// before their grace period is over.
+var drainMap = struct {
+	sync.Mutex
+	data map[string]bool
+}{data: make(map[string]bool)}
+
+// drainKeyPath returns the key of the drain marker for a published key, e.g.
+// /deis/draining/services/<app>/<container> for /deis/services/<app>/<container>.
+func drainKeyPath(key string) string {
+	return "/deis/draining/" + strings.TrimPrefix(key, "/deis/")
+}
+
+// isDraining determines if a published key is draining.
+func isDraining(key string) bool {
+	drainMap.Lock()
+	defer drainMap.Unlock()
+	return drainMap.data[key]
+}
+
+// drainContainer unpublishes a container after a grace period. During the grace period the
+// container's keys are kept and marked as draining, so the router stops sending new
+// connections to the container while in-flight requests complete. A container which is not
+// published, e.g. because it is unhealthy, is unpublished right away.
+func (s *Server) drainContainer(id string, grace time.Duration) {
+	if grace <= 0 {
+		s.removeContainer(id)
+		return
+	}
+	stopMonitor(id)
+	forgetHealth(id)
+	info := uncacheContainer(id)
+	if info == nil {
+		return
+	}
+	if !info.Published {
+		// the router sends it no traffic, so there is nothing to drain
+		for key := range info.Keys {
+			log.Printf("stopped %s\n", key)
+			s.removeKey(key)
+		}
+		return
+	}
+	// keys expire with a granularity of one second
+	ttl := grace
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	drainMap.Lock()
+	for key := range info.Keys {
+		drainMap.data[key] = true
+	}
+	drainMap.Unlock()
+	for key, value := range info.Keys {
+		log.Printf("draining %s\n", key)
+		s.setKey(drainKeyPath(key), value, ttl)
+		// make sure the key outlives the grace period
+		s.setKey(key, value, ttl)
+	}
+
+	time.AfterFunc(grace, func() {
+		// the container may have been restarted and published again in the meantime
+		republished := cachedContainer(id) != nil
+		for key := range info.Keys {
+			if !republished {
+				log.Printf("stopped %s\n", key)
+				s.removeKey(key)
+			}
+			s.removeKey(drainKeyPath(key))
+		}
+		if !republished && info.Published {
+			s.notifyUnpublished(info, "container stopped")
+		}
+		drainMap.Lock()
+		for key := range info.Keys {
+			delete(drainMap.data, key)
+		}
+		drainMap.Unlock()
+	})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDrainKeyPath(t *testing.T) {
+	expected := "/deis/draining/services/go/go_v2.web.1"
+	if key := drainKeyPath("/deis/services/go/go_v2.web.1"); key != expected {
+		t.Errorf("expected %s, got %s", expected, key)
+	}
+}
+
+func TestDrainContainer(t *testing.T) {
+	fake := newFakeRegistry(nil)
+	s := &Server{Config: fake, Registry: fake}
+	keyPath := "/deis/services/go/go_v2.web.1"
+	cacheContainer("TestDrainContainer", &containerInfo{
+		Name:      "go_v2.web.1",
+		Keys:      map[string]string{keyPath: "10.0.0.1:49153"},
+		Published: true,
+	})
+
+	s.drainContainer("TestDrainContainer", 50*time.Millisecond)
+	fake.waitFor(t, "set /deis/draining/services/go/go_v2.web.1")
+	if !isDraining(keyPath) {
+		t.Errorf("%s should be draining", keyPath)
+	}
+	if cachedContainer("TestDrainContainer") != nil {
+		t.Errorf("draining containers should no longer be cached")
+	}
+
+	fake.waitFor(t, "delete "+keyPath)
+	fake.waitFor(t, "delete /deis/draining/services/go/go_v2.web.1")
+	for i := 0; i < 100 && isDraining(keyPath); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if isDraining(keyPath) {
+		t.Errorf("%s should no longer be draining", keyPath)
+	}
+}
+
+func TestDrainUnpublishedContainer(t *testing.T) {
+	fake := newFakeRegistry(nil)
+	s := &Server{Config: fake, Registry: fake}
+	keyPath := "/deis/services/go/go_v2.web.1"
+	cacheContainer("TestDrainUnpublishedContainer", &containerInfo{
+		Name: "go_v2.web.1",
+		Keys: map[string]string{keyPath: "10.0.0.1:49153"},
+	})
+
+	s.drainContainer("TestDrainUnpublishedContainer", 50*time.Millisecond)
+	fake.waitFor(t, "delete "+keyPath)
+	if isDraining(keyPath) {
+		t.Errorf("%s should not be draining", keyPath)
+	}
+	fake.Lock()
+	defer fake.Unlock()
+	for _, op := range fake.ops {
+		if strings.HasPrefix(op, "set ") {
+			t.Errorf("an unpublished container should not be published again, got %q", op)
+		}
+	}
+}
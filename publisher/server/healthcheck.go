@@ -0,0 +1,306 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HealthCheckTCP checks that the container's port accepts tcp connections.
+	HealthCheckTCP = "tcp"
+	// HealthCheckHTTP performs an HTTP GET against the container's healthcheck URL.
+	HealthCheckHTTP = "http"
+
+	// maxBodyMatchBytes is the amount of the response body read when matching against
+	// healthcheck_body.
+	maxBodyMatchBytes = 64 * 1024
+)
+
+// healthCheckKeys are the keys under /deis/config/<app>/ that configure a health check.
+var healthCheckKeys = []string{
+	"healthcheck_type",
+	"healthcheck_url",
+	"healthcheck_initial_delay",
+	"healthcheck_timeout",
+	"healthcheck_interval",
+	"healthcheck_healthy_threshold",
+	"healthcheck_unhealthy_threshold",
+	"healthcheck_status",
+	"healthcheck_body",
+}
+
+// HealthCheck describes how a container's backend is probed before and while it is published.
+type HealthCheck struct {
+	// Type is either HealthCheckTCP or HealthCheckHTTP.
+	Type string
+	// URL is the path requested for HTTP health checks.
+	URL string
+	// InitialDelay is the time to wait before the first probe of a new container.
+	InitialDelay time.Duration
+	// Timeout is the time to wait for a single probe to complete.
+	Timeout time.Duration
+	// Interval is the time to wait between consecutive probes.
+	Interval time.Duration
+	// HealthyThreshold is the number of consecutive successful probes required to mark
+	// an unhealthy container as healthy.
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failed probes required to mark a
+	// healthy container as unhealthy.
+	UnhealthyThreshold int
+	// StatusMin and StatusMax are the inclusive range of accepted HTTP status codes.
+	StatusMin int
+	StatusMax int
+	// Body, if set, must be contained in the HTTP response body.
+	Body string
+}
+
+// NewHealthCheck builds a HealthCheck from an application's healthcheck_* config values.
+// Missing or invalid values fall back to their defaults. If no type is given, an HTTP check
+// is used when healthcheck_url is set and a TCP check otherwise.
+func NewHealthCheck(config map[string]string) *HealthCheck {
+	hc := &HealthCheck{
+		Type:               HealthCheckTCP,
+		URL:                config["healthcheck_url"],
+		InitialDelay:       configSeconds(config, "healthcheck_initial_delay", 0),
+		Timeout:            configSeconds(config, "healthcheck_timeout", 1),
+		Interval:           configSeconds(config, "healthcheck_interval", 1),
+		HealthyThreshold:   configInt(config, "healthcheck_healthy_threshold", 1),
+		UnhealthyThreshold: configInt(config, "healthcheck_unhealthy_threshold", 3),
+		StatusMin:          http.StatusOK,
+		StatusMax:          http.StatusOK,
+		Body:               config["healthcheck_body"],
+	}
+	if hc.URL != "" {
+		hc.Type = HealthCheckHTTP
+	}
+	switch t := strings.ToLower(config["healthcheck_type"]); t {
+	case "":
+	case HealthCheckTCP, HealthCheckHTTP:
+		hc.Type = t
+	default:
+		log.Printf("unknown healthcheck_type %q, using %s\n", t, hc.Type)
+	}
+	if hc.Type == HealthCheckHTTP && hc.URL == "" {
+		hc.URL = "/"
+	}
+	if status := config["healthcheck_status"]; status != "" {
+		min, max, err := parseStatusRange(status)
+		if err != nil {
+			log.Println(err)
+		} else {
+			hc.StatusMin, hc.StatusMax = min, max
+		}
+	}
+	if hc.HealthyThreshold < 1 {
+		hc.HealthyThreshold = 1
+	}
+	if hc.UnhealthyThreshold < 1 {
+		hc.UnhealthyThreshold = 1
+	}
+	return hc
+}
+
+// Probe performs a single health check against hostAndPort, returning an error describing
+// the failure if the backend is not healthy.
+func (h *HealthCheck) Probe(hostAndPort string) error {
+	if h.Type == HealthCheckHTTP {
+		return h.probeHTTP("http://" + hostAndPort + h.URL)
+	}
+	conn, err := net.DialTimeout("tcp", hostAndPort, h.Timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (h *HealthCheck) probeHTTP(url string) error {
+	client := http.Client{
+		Timeout: h.Timeout,
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < h.StatusMin || resp.StatusCode > h.StatusMax {
+		if h.StatusMin == h.StatusMax {
+			return fmt.Errorf("expected %d, got %d", h.StatusMin, resp.StatusCode)
+		}
+		return fmt.Errorf("expected %d-%d, got %d", h.StatusMin, h.StatusMax, resp.StatusCode)
+	}
+	if h.Body != "" {
+		body, err := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: maxBodyMatchBytes})
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(body), h.Body) {
+			return fmt.Errorf("response body does not contain %q", h.Body)
+		}
+	}
+	return nil
+}
+
+// healthState tracks consecutive probe results for a published container.
+type healthState struct {
+	healthy   bool
+	successes int
+	failures  int
+	// probing is true while the first probes of a new container run.
+	probing bool
+}
+
+// record adds a probe result to the state and returns whether the container is healthy.
+// A container only changes state once the corresponding threshold is reached.
+func (st *healthState) record(ok bool, h *HealthCheck) bool {
+	if ok {
+		st.successes++
+		st.failures = 0
+		if !st.healthy && st.successes >= h.HealthyThreshold {
+			st.healthy = true
+		}
+	} else {
+		st.failures++
+		st.successes = 0
+		if st.healthy && st.failures >= h.UnhealthyThreshold {
+			st.healthy = false
+		}
+	}
+	return st.healthy
+}
+
+// settled returns true once a new container has passed or failed enough probes to decide
+// its initial state.
+func (st *healthState) settled(h *HealthCheck) bool {
+	return st.healthy || st.failures >= h.UnhealthyThreshold
+}
+
+var healthMap = struct {
+	sync.Mutex
+	data map[string]*healthState
+}{data: make(map[string]*healthState)}
+
+// healthCheckFor retrieves the health check configuration for an application.
+func (s *Server) healthCheckFor(appName string) *HealthCheck {
+	configKey := fmt.Sprintf("/deis/config/%s/", appName)
+	config := make(map[string]string)
+	for _, key := range healthCheckKeys {
+		if value := s.getConfig(configKey + key); value != "" {
+			config[key] = value
+		}
+	}
+	return NewHealthCheck(config)
+}
+
+// IsHealthy probes the container's backend and returns whether it should be published.
+//
+// The first time a container is seen, it is probed every interval (after the initial delay)
+// until it either reaches the healthy threshold or fails enough probes to reach the
+// unhealthy threshold. A container which flaps is given up on as unhealthy after as many
+// probes as both thresholds together. Calls made in the meantime do not probe, and return
+// false. Afterwards,
+// each call performs a single probe, so a container only changes state once a threshold of
+// consecutive results is reached.
+func (s *Server) IsHealthy(id, hostAndPort string, h *HealthCheck) bool {
+	healthMap.Lock()
+	st, ok := healthMap.data[id]
+	if !ok {
+		st = &healthState{probing: true}
+		healthMap.data[id] = st
+	}
+	probing := st.probing
+	healthMap.Unlock()
+
+	if ok && probing {
+		return false
+	}
+
+	if !ok {
+		initial := &healthState{}
+		time.Sleep(h.InitialDelay)
+		for probes := 1; ; probes++ {
+			err := h.Probe(hostAndPort)
+			if err != nil {
+				log.Printf("healthcheck failed for %s (%v)\n", hostAndPort, err)
+			}
+			initial.record(err == nil, h)
+			if initial.settled(h) || probes >= h.HealthyThreshold+h.UnhealthyThreshold {
+				break
+			}
+			time.Sleep(h.Interval)
+		}
+		healthMap.Lock()
+		*st = *initial
+		healthMap.Unlock()
+		return initial.healthy
+	}
+
+	err := h.Probe(hostAndPort)
+	if err != nil {
+		log.Printf("healthcheck failed for %s (%v)\n", hostAndPort, err)
+	}
+	healthMap.Lock()
+	defer healthMap.Unlock()
+	return st.record(err == nil, h)
+}
+
+// isHealthy returns whether a container was last known to be healthy.
+func isHealthy(id string) bool {
+	healthMap.Lock()
+	defer healthMap.Unlock()
+	st, ok := healthMap.data[id]
+	return ok && st.healthy
+}
+
+// forgetHealth discards the health state of a container.
+func forgetHealth(id string) {
+	healthMap.Lock()
+	delete(healthMap.data, id)
+	healthMap.Unlock()
+}
+
+// parseStatusRange parses an HTTP status code ("200") or an inclusive range ("200-399").
+func parseStatusRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid healthcheck_status %q: %v", s, err)
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, fmt.Errorf("invalid healthcheck_status %q: %v", s, err)
+		}
+	}
+	if min > max {
+		return 0, 0, fmt.Errorf("invalid healthcheck_status %q: %d is greater than %d", s, min, max)
+	}
+	return min, max, nil
+}
+
+// configInt returns the integer value of a config key, or def if it is unset or invalid.
+func configInt(config map[string]string, key string, def int) int {
+	value, ok := config[key]
+	if !ok || value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Println(err)
+		return def
+	}
+	return i
+}
+
+// configSeconds returns the value of a config key in seconds as a duration, or def seconds
+// if it is unset or invalid.
+func configSeconds(config map[string]string, key string, def int) time.Duration {
+	return time.Duration(configInt(config, key, def)) * time.Second
+}
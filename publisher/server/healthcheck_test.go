@@ -0,0 +1,203 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHealthCheck(t *testing.T) {
+	hc := NewHealthCheck(map[string]string{})
+	if hc.Type != HealthCheckTCP {
+		t.Errorf("expected default type %s, got %s", HealthCheckTCP, hc.Type)
+	}
+	if hc.Timeout != time.Second || hc.Interval != time.Second {
+		t.Errorf("unexpected default timeout %v or interval %v", hc.Timeout, hc.Interval)
+	}
+	if hc.HealthyThreshold != 1 || hc.UnhealthyThreshold != 3 {
+		t.Errorf("unexpected default thresholds %d/%d", hc.HealthyThreshold, hc.UnhealthyThreshold)
+	}
+
+	hc = NewHealthCheck(map[string]string{
+		"healthcheck_url":                 "/health",
+		"healthcheck_initial_delay":       "5",
+		"healthcheck_unhealthy_threshold": "bogus",
+		"healthcheck_status":              "200-399",
+		"healthcheck_body":                "OK",
+	})
+	if hc.Type != HealthCheckHTTP {
+		t.Errorf("expected type %s when a URL is set, got %s", HealthCheckHTTP, hc.Type)
+	}
+	if hc.InitialDelay != 5*time.Second {
+		t.Errorf("expected initial delay of 5s, got %v", hc.InitialDelay)
+	}
+	if hc.UnhealthyThreshold != 3 {
+		t.Errorf("expected invalid threshold to fall back to 3, got %d", hc.UnhealthyThreshold)
+	}
+	if hc.StatusMin != 200 || hc.StatusMax != 399 {
+		t.Errorf("expected status range 200-399, got %d-%d", hc.StatusMin, hc.StatusMax)
+	}
+
+	hc = NewHealthCheck(map[string]string{"healthcheck_type": "HTTP"})
+	if hc.Type != HealthCheckHTTP || hc.URL != "/" {
+		t.Errorf("expected an http check against /, got %s against %q", hc.Type, hc.URL)
+	}
+}
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		in       string
+		min, max int
+		err      bool
+	}{
+		{"200", 200, 200, false},
+		{"200-299", 200, 299, false},
+		{" 200 - 204 ", 200, 204, false},
+		{"299-200", 0, 0, true},
+		{"abc", 0, 0, true},
+		{"200-", 0, 0, true},
+	}
+	for _, tt := range tests {
+		min, max, err := parseStatusRange(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseStatusRange(%q): unexpected error state %v", tt.in, err)
+			continue
+		}
+		if min != tt.min || max != tt.max {
+			t.Errorf("parseStatusRange(%q) = %d, %d; want %d, %d", tt.in, min, max, tt.min, tt.max)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/created" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		fmt.Fprintln(w, "status: OK")
+	}))
+	defer ts.Close()
+	hostAndPort := strings.TrimPrefix(ts.URL, "http://")
+
+	hc := &HealthCheck{Type: HealthCheckTCP, Timeout: time.Second}
+	if err := hc.Probe(hostAndPort); err != nil {
+		t.Errorf("tcp probe should pass: %v", err)
+	}
+
+	hc = &HealthCheck{Type: HealthCheckHTTP, URL: "/created", Timeout: time.Second, StatusMin: 200, StatusMax: 200}
+	if err := hc.Probe(hostAndPort); err == nil {
+		t.Errorf("http probe should fail on an unexpected status")
+	}
+	hc.StatusMax = 299
+	if err := hc.Probe(hostAndPort); err != nil {
+		t.Errorf("http probe should pass within the status range: %v", err)
+	}
+	hc.Body = "status: OK"
+	if err := hc.Probe(hostAndPort); err != nil {
+		t.Errorf("http probe should pass when the body matches: %v", err)
+	}
+	hc.Body = "status: FAIL"
+	if err := hc.Probe(hostAndPort); err == nil {
+		t.Errorf("http probe should fail when the body does not match")
+	}
+}
+
+func TestHealthStateThresholds(t *testing.T) {
+	hc := &HealthCheck{HealthyThreshold: 2, UnhealthyThreshold: 3}
+	st := &healthState{}
+	if st.record(true, hc) {
+		t.Errorf("container should not be healthy after a single success")
+	}
+	if !st.record(true, hc) {
+		t.Errorf("container should be healthy after reaching the healthy threshold")
+	}
+	if !st.record(false, hc) || !st.record(false, hc) {
+		t.Errorf("container should stay healthy below the unhealthy threshold")
+	}
+	if !st.record(true, hc) {
+		t.Errorf("container should stay healthy after a success")
+	}
+	for i := 0; i < 2; i++ {
+		st.record(false, hc)
+	}
+	if st.record(false, hc) {
+		t.Errorf("container should be unhealthy after reaching the unhealthy threshold")
+	}
+}
+
+func TestIsHealthy(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	hostAndPort := ln.Addr().String()
+
+	s := &Server{}
+	hc := &HealthCheck{Type: HealthCheckTCP, Timeout: time.Second, HealthyThreshold: 1, UnhealthyThreshold: 2}
+	id := "TestIsHealthy"
+	defer forgetHealth(id)
+	if !s.IsHealthy(id, hostAndPort, hc) {
+		t.Errorf("container should be healthy")
+	}
+	ln.Close()
+	if !s.IsHealthy(id, hostAndPort, hc) {
+		t.Errorf("a single failed probe should not mark the container unhealthy")
+	}
+	if s.IsHealthy(id, hostAndPort, hc) {
+		t.Errorf("container should be unhealthy after reaching the unhealthy threshold")
+	}
+}
+
+func TestIsHealthyConcurrent(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	hostAndPort := ln.Addr().String()
+
+	s := &Server{}
+	hc := &HealthCheck{Type: HealthCheckTCP, InitialDelay: 100 * time.Millisecond, Timeout: time.Second,
+		HealthyThreshold: 1, UnhealthyThreshold: 2}
+	id := "TestIsHealthyConcurrent"
+	defer forgetHealth(id)
+	first := make(chan bool)
+	go func() { first <- s.IsHealthy(id, hostAndPort, hc) }()
+	time.Sleep(10 * time.Millisecond)
+	if s.IsHealthy(id, hostAndPort, hc) {
+		t.Errorf("container should not be healthy while its first probes run")
+	}
+	if !<-first {
+		t.Errorf("container should be healthy")
+	}
+	if !s.IsHealthy(id, hostAndPort, hc) {
+		t.Errorf("container should stay healthy once its first probes settled")
+	}
+}
+
+func TestIsHealthyFlapping(t *testing.T) {
+	var probes int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes++
+		if probes%2 == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	s := &Server{}
+	hc := &HealthCheck{Type: HealthCheckHTTP, URL: "/", Timeout: time.Second, Interval: time.Millisecond,
+		HealthyThreshold: 2, UnhealthyThreshold: 2, StatusMin: http.StatusOK, StatusMax: http.StatusOK}
+	id := "TestIsHealthyFlapping"
+	defer forgetHealth(id)
+	if s.IsHealthy(id, strings.TrimPrefix(ts.URL, "http://"), hc) {
+		t.Errorf("a flapping container should be unhealthy")
+	}
+	if probes != 4 {
+		t.Errorf("expected the first round to stop after 4 probes, got %d", probes)
+	}
+}
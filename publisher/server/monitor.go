@@ -0,0 +1,123 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// healthMonitor continuously probes a published container's backend.
+type healthMonitor struct {
+	sync.Mutex
+	check *HealthCheck
+	stop  chan struct{}
+}
+
+// healthCheck returns the health check currently used by the monitor.
+func (m *healthMonitor) healthCheck() *HealthCheck {
+	m.Lock()
+	defer m.Unlock()
+	return m.check
+}
+
+// setHealthCheck replaces the health check used by the monitor, so configuration changes
+// picked up during a poll take effect on the next probe.
+func (m *healthMonitor) setHealthCheck(h *HealthCheck) {
+	m.Lock()
+	m.check = h
+	m.Unlock()
+}
+
+var monitorMap = struct {
+	sync.Mutex
+	data map[string]*healthMonitor
+}{data: make(map[string]*healthMonitor)}
+
+// getMonitor returns the health monitor of a container, or nil if it is not monitored.
+func getMonitor(id string) *healthMonitor {
+	monitorMap.Lock()
+	defer monitorMap.Unlock()
+	return monitorMap.data[id]
+}
+
+// MonitorHealth starts a goroutine that probes the container's backend at hostAndPort every
+// interval until the container is removed. When the unhealthy threshold is reached the
+// container's keys are removed from the registry, and they are published again once the healthy
+// threshold is reached. If the container is already monitored, its health check is updated
+// instead.
+func (s *Server) MonitorHealth(id, containerName, hostAndPort string, keys map[string]string, h *HealthCheck, ttl time.Duration) {
+	monitorMap.Lock()
+	defer monitorMap.Unlock()
+	if m, ok := monitorMap.data[id]; ok {
+		m.setHealthCheck(h)
+		return
+	}
+	m := &healthMonitor{check: h, stop: make(chan struct{})}
+	monitorMap.data[id] = m
+	go s.monitor(m, id, containerName, hostAndPort, keys, ttl)
+}
+
+func (s *Server) monitor(m *healthMonitor, id, containerName, hostAndPort string, keys map[string]string, ttl time.Duration) {
+	for {
+		h := m.healthCheck()
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(h.Interval):
+		}
+		err := h.Probe(hostAndPort)
+		if err != nil {
+			log.Printf("healthcheck failed for %s (%v)\n", containerName, err)
+		}
+
+		healthMap.Lock()
+		st, ok := healthMap.data[id]
+		if !ok {
+			st = &healthState{}
+			healthMap.data[id] = st
+		} else if st.probing {
+			// IsHealthy records the first probes of the container
+			healthMap.Unlock()
+			continue
+		}
+		wasHealthy := st.healthy
+		healthy := st.record(err == nil, h)
+		healthMap.Unlock()
+
+		// the monitor may have been stopped while probing
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+
+		if wasHealthy && !healthy {
+			log.Printf("unhealthy %s\n", containerName)
+			for key := range keys {
+				s.removeKey(key)
+			}
+			if info := setPublished(id, false); info != nil {
+				s.notify(EventHealthCheckFailed, info.App, containerName, hostAndPort, err.Error())
+				s.notifyUnpublished(info, "unhealthy")
+			}
+		} else if !wasHealthy && healthy && s.IsPublishableApp(containerName) {
+			log.Printf("recovered %s\n", containerName)
+			for key, value := range keys {
+				s.setKey(key, value, ttl)
+			}
+			if info := setPublished(id, true); info != nil {
+				s.notify(EventPublished, info.App, containerName, hostAndPort, "recovered")
+			}
+		}
+	}
+}
+
+// stopMonitor stops the health monitor of a container, if any.
+func stopMonitor(id string) {
+	monitorMap.Lock()
+	defer monitorMap.Unlock()
+	if m, ok := monitorMap.data[id]; ok {
+		close(m.stop)
+		delete(monitorMap.data, id)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMonitorHealth(t *testing.T) {
+	fake := newFakeRegistry(nil)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	hostAndPort := ln.Addr().String()
+
+	s := &Server{Config: fake, Registry: fake}
+	id := "TestMonitorHealth"
+	keyPath := "/deis/services/go/go_v2.web.1"
+	hc := &HealthCheck{
+		Type:               HealthCheckTCP,
+		Timeout:            100 * time.Millisecond,
+		Interval:           10 * time.Millisecond,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 2,
+	}
+	healthMap.Lock()
+	healthMap.data[id] = &healthState{healthy: true}
+	healthMap.Unlock()
+	defer forgetHealth(id)
+
+	keys := map[string]string{keyPath: hostAndPort}
+	s.MonitorHealth(id, "go_v2.web.1", hostAndPort, keys, hc, time.Minute)
+	defer stopMonitor(id)
+	if getMonitor(id) == nil {
+		t.Fatalf("container should be monitored")
+	}
+
+	ln.Close()
+	fake.waitFor(t, "delete "+keyPath)
+	if isHealthy(id) {
+		t.Errorf("container should be unhealthy")
+	}
+
+	ln, err = net.Listen("tcp4", hostAndPort)
+	if err != nil {
+		t.Skipf("could not listen on %s again: %v", hostAndPort, err)
+	}
+	defer ln.Close()
+	fake.waitFor(t, "set "+keyPath)
+	if !isHealthy(id) {
+		t.Errorf("container should have recovered")
+	}
+
+	stopMonitor(id)
+	if getMonitor(id) != nil {
+		t.Errorf("container should no longer be monitored")
+	}
+}
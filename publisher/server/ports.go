@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// defaultRoutableTypes are the process types which are always routable. Only these are
+// published under /deis/services, which the router uses for an application's hostname.
+var defaultRoutableTypes = []string{"cmd", "web"}
+
+// isDefaultRoutableType determines if the process type is routed by the application's hostname.
+func isDefaultRoutableType(procType string) bool {
+	for _, t := range defaultRoutableTypes {
+		if t == procType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRoutableType determines if the process type of an application should be published.
+// Besides the default routable types, applications can make other process types routable
+// by setting deis_routable_types to a comma-separated list of process types.
+func (s *Server) IsRoutableType(appName, procType string) bool {
+	if isDefaultRoutableType(procType) {
+		return true
+	}
+	routableTypes := s.getConfig(fmt.Sprintf("/deis/config/%s/deis_routable_types", appName))
+	for _, t := range strings.Split(routableTypes, ",") {
+		if strings.TrimSpace(t) == procType {
+			return true
+		}
+	}
+	return false
+}
+
+// publishedPorts returns the container's ports which are bound to the host, lowest private
+// port first. If only is non-zero, only the port exposed as only is returned.
+func publishedPorts(ports []docker.APIPort, only int64) []docker.APIPort {
+	var published []docker.APIPort
+	seen := make(map[int64]bool)
+	for _, p := range ports {
+		if p.PublicPort == 0 {
+			// the port is exposed, but not bound to the host
+			continue
+		}
+		if (only != 0 && p.PrivatePort != only) || seen[p.PrivatePort] {
+			continue
+		}
+		seen[p.PrivatePort] = true
+		published = append(published, p)
+	}
+	sort.Sort(byPrivatePort(published))
+	return published
+}
+
+// publishPort returns the exposed port an application wants published, or 0 for every port.
+func (s *Server) publishPort(appName string) int64 {
+	value := s.getConfig(fmt.Sprintf("/deis/config/%s/deis_publish_port", appName))
+	if value == "" {
+		return 0
+	}
+	port, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return port
+}
+
+// portKeyPath returns the registry key under which a single port of a container is published:
+// /deis/ports/<app>/<process type>/<exposed port>/<container>.
+func portKeyPath(appName, procType string, port int64, containerName string) string {
+	return fmt.Sprintf("/deis/ports/%s/%s/%d/%s", appName, procType, port, containerName)
+}
+
+type byPrivatePort []docker.APIPort
+
+func (p byPrivatePort) Len() int           { return len(p) }
+func (p byPrivatePort) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPrivatePort) Less(i, j int) bool { return p[i].PrivatePort < p[j].PrivatePort }
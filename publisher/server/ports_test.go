@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestPublishedPorts(t *testing.T) {
+	ports := []docker.APIPort{
+		{PrivatePort: 9090, PublicPort: 49155, Type: "tcp", IP: "0.0.0.0"},
+		{PrivatePort: 5000, PublicPort: 49154, Type: "tcp", IP: "0.0.0.0"},
+		{PrivatePort: 5000, PublicPort: 49154, Type: "tcp", IP: "::"},
+		{PrivatePort: 6000, Type: "tcp"},
+	}
+	published := publishedPorts(ports, 0)
+	if len(published) != 2 {
+		t.Fatalf("expected 2 published ports, got %d", len(published))
+	}
+	if published[0].PrivatePort != 5000 || published[1].PrivatePort != 9090 {
+		t.Errorf("expected ports to be sorted by private port, got %v", published)
+	}
+
+	published = publishedPorts(ports, 9090)
+	if len(published) != 1 || published[0].PublicPort != 49155 {
+		t.Errorf("expected only port 9090 to be published, got %v", published)
+	}
+
+	if published = publishedPorts(ports, 6000); len(published) != 0 {
+		t.Errorf("unbound ports should not be published, got %v", published)
+	}
+}
+
+func TestIsDefaultRoutableType(t *testing.T) {
+	for _, procType := range []string{"web", "cmd"} {
+		if !isDefaultRoutableType(procType) {
+			t.Errorf("%s should be routable", procType)
+		}
+	}
+	if isDefaultRoutableType("worker") {
+		t.Errorf("worker should not be routable by default")
+	}
+}
+
+func TestPortKeyPath(t *testing.T) {
+	expected := "/deis/ports/go/api/5000/go_v2.api.1"
+	if key := portKeyPath("go", "api", 5000, "go_v2.api.1"); key != expected {
+		t.Errorf("expected %s, got %s", expected, key)
+	}
+}
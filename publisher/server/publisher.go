@@ -5,46 +5,50 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"path"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/fsouza/go-dockerclient"
 )
 
 const (
-	appNameRegex string = `([a-z0-9-]+)_v([1-9][0-9]*).(cmd|web).([1-9][0-9])*`
+	appNameRegex string = `([a-z0-9-]+)_v([1-9][0-9]*).([a-z]+).([1-9][0-9])*`
 )
 
 // Server is the main entrypoint for a publisher. It listens on a docker client for events
-// and publishes their host:port to the etcd client.
+// and publishes their host:port to the registry.
 type Server struct {
 	DockerClient *docker.Client
-	EtcdClient   *etcd.Client
+	// Config is where application config is read from, i.e. /deis/config.
+	Config Registry
+	// Registry is where backends are published to, i.e. /deis/services and /deis/ports.
+	Registry Registry
+	// Webhook is notified when backends are published or unpublished.
+	Webhook *Webhook
 
 	host     string
 	logLevel string
 }
 
-var safeMap = struct {
-	sync.RWMutex
-	data map[string]string
-}{data: make(map[string]string)}
-
 // New returns a new instance of Server.
-func New(dockerClient *docker.Client, etcdClient *etcd.Client, host, logLevel string) *Server {
+func New(dockerClient *docker.Client, config, registry Registry, host, logLevel string) *Server {
 	return &Server{
 		DockerClient: dockerClient,
-		EtcdClient:   etcdClient,
+		Config:       config,
+		Registry:     registry,
+		Webhook:      NewWebhook(config),
 		host:         host,
 		logLevel:     logLevel,
 	}
 }
 
 // Listen adds an event listener to the docker client and publishes containers that were started.
-func (s *Server) Listen(ttl time.Duration) {
+// Containers that were stopped are drained for the given duration before they are unpublished.
+func (s *Server) Listen(ttl, drain time.Duration) {
 	listener := make(chan *docker.APIEvents)
 	// TODO: figure out why we need to sleep for 10 milliseconds
 	// https://github.com/fsouza/go-dockerclient/blob/0236a64c6c4bd563ec277ba00e370cc753e1677c/event_test.go#L43
@@ -63,20 +67,33 @@ func (s *Server) Listen(ttl time.Duration) {
 				}
 				s.publishContainer(container, ttl)
 			} else if event.Status == "stop" {
-				s.removeContainer(event.ID)
+				s.drainContainer(event.ID, drain)
 			}
 		}
 	}
 }
 
-// Poll lists all containers from the docker client every time the TTL comes up and publishes them to etcd
+// Poll lists all containers from the docker client every time the TTL comes up and publishes them to the registry.
+// It also reconciles the local cache against docker and the registry: containers which are no longer
+// running are unpublished, as are keys published by this host for containers which no longer exist.
 func (s *Server) Poll(ttl time.Duration) {
+	cached := cachedContainerIDs()
+	services := s.listKeys("/deis/services")
+	ports := s.listKeys("/deis/ports")
+	updateAppVersions(services)
+
 	containers, err := s.DockerClient.ListContainers(docker.ListContainersOptions{})
 	if err != nil {
 		log.Fatal(err)
 	}
 	var wg sync.WaitGroup
+	running := make(map[string]bool)
+	names := make(map[string]bool)
 	for _, container := range containers {
+		running[container.ID] = true
+		for _, name := range container.Names {
+			names[strings.TrimPrefix(name, "/")] = true
+		}
 		wg.Add(1)
 		go func(container docker.APIContainers, ttl time.Duration) {
 			defer wg.Done()
@@ -86,24 +103,42 @@ func (s *Server) Poll(ttl time.Duration) {
 	}
 	// Wait for all publish operations to complete.
 	wg.Wait()
+
+	// unpublish containers which stopped without us noticing
+	for _, id := range cached {
+		if !running[id] {
+			s.removeContainer(id)
+		}
+	}
+	// remove keys published by this host for containers which no longer exist
+	for _, keys := range []map[string]string{services, ports} {
+		for key, value := range keys {
+			if strings.HasPrefix(value, s.host+":") && !names[path.Base(key)] && !isDraining(key) {
+				log.Printf("stale %s\n", key)
+				s.removeKey(key)
+			}
+		}
+	}
 }
 
 // getContainer retrieves a container from the docker client based on id
 func (s *Server) getContainer(id string) (*docker.APIContainers, error) {
-	containers, err := s.DockerClient.ListContainers(docker.ListContainersOptions{})
+	container, err := s.DockerClient.InspectContainer(id)
 	if err != nil {
 		return nil, err
 	}
-	for _, container := range containers {
-		// send container to channel for processing
-		if container.ID == id {
-			return &container, nil
-		}
-	}
-	return nil, fmt.Errorf("could not find container with id %v", id)
+	return &docker.APIContainers{
+		ID:    container.ID,
+		Names: []string{container.Name},
+		Ports: container.NetworkSettings.PortMappingAPI(),
+	}, nil
 }
 
-// publishContainer publishes the docker container to etcd.
+// publishContainer publishes the docker container to the registry.
+//
+// Every port bound to the host is published under /deis/ports/<app>/<type>/<port>/<container>,
+// unless the application configured deis_publish_port. The lowest port of cmd and web
+// containers is also published under /deis/services/<app>/<container>.
 func (s *Server) publishContainer(container *docker.APIContainers, ttl time.Duration) {
 	r := regexp.MustCompile(appNameRegex)
 	for _, name := range container.Names {
@@ -115,69 +150,79 @@ func (s *Server) publishContainer(container *docker.APIContainers, ttl time.Dura
 			continue
 		}
 		appName := match[1]
-		appPath := fmt.Sprintf("%s/%s", appName, containerName)
-		keyPath := fmt.Sprintf("/deis/services/%s", appPath)
-		for _, p := range container.Ports {
-			var delay int
-			var timeout int
-			var err error
-			// lowest port wins (docker sorts the ports)
-			// TODO (bacongobbler): support multiple exposed ports
-			port := strconv.Itoa(int(p.PublicPort))
-			hostAndPort := s.host + ":" + port
-			if s.IsPublishableApp(containerName) && s.IsPortOpen(hostAndPort) {
-				configKey := fmt.Sprintf("/deis/config/%s/", appName)
-				// check if the user specified a healthcheck URL
-				healthcheckURL := s.getEtcd(configKey + "healthcheck_url")
-				initialDelay := s.getEtcd(configKey + "healthcheck_initial_delay")
-				if initialDelay != "" {
-					delay, err = strconv.Atoi(initialDelay)
-					if err != nil {
-						log.Println(err)
-						delay = 0
-					}
-				} else {
-					delay = 0
-				}
-				healthcheckTimeout := s.getEtcd(configKey + "healthcheck_timeout")
-				if healthcheckTimeout != "" {
-					timeout, err = strconv.Atoi(healthcheckTimeout)
-					if err != nil {
-						log.Println(err)
-						timeout = 1
-					}
-				} else {
-					timeout = 1
-				}
-				if healthcheckURL != "" {
-					if !s.HealthCheckOK("http://"+hostAndPort+healthcheckURL, delay, timeout) {
-						continue
-					}
+		procType := match[3]
+		if !s.IsPublishableApp(containerName) || !s.IsRoutableType(appName, procType) {
+			continue
+		}
+		ports := publishedPorts(container.Ports, s.publishPort(appName))
+		if len(ports) == 0 {
+			continue
+		}
+		keys := make(map[string]string)
+		for _, p := range ports {
+			hostAndPort := s.host + ":" + strconv.Itoa(int(p.PublicPort))
+			keys[portKeyPath(appName, procType, p.PrivatePort, containerName)] = hostAndPort
+		}
+		// lowest port wins
+		hostAndPort := s.host + ":" + strconv.Itoa(int(ports[0].PublicPort))
+		if isDefaultRoutableType(procType) {
+			keys[fmt.Sprintf("/deis/services/%s/%s", appName, containerName)] = hostAndPort
+		}
+
+		hc := s.healthCheckFor(appName)
+		var healthy bool
+		if m := getMonitor(container.ID); m != nil {
+			// the health monitor is already probing this container
+			m.setHealthCheck(hc)
+			healthy = isHealthy(container.ID)
+		} else {
+			healthy = s.IsHealthy(container.ID, hostAndPort, hc)
+		}
+		if healthy {
+			for key, value := range keys {
+				s.setKey(key, value, ttl)
+				if isDraining(key) {
+					// the container was restarted before its grace period was over
+					s.removeKey(drainKeyPath(key))
 				}
-				s.setEtcd(keyPath, hostAndPort, uint64(ttl.Seconds()))
-				safeMap.Lock()
-				safeMap.data[container.ID] = appPath
-				safeMap.Unlock()
 			}
-			break
 		}
+		version, _ := strconv.Atoi(match[2])
+		prev := cacheContainer(container.ID, &containerInfo{
+			Name:      containerName,
+			App:       appName,
+			ProcType:  procType,
+			Version:   version,
+			Keys:      keys,
+			Published: healthy,
+		})
+		if healthy && (prev == nil || !prev.Published) {
+			s.notify(EventPublished, appName, containerName, hostAndPort, "")
+		} else if !healthy && prev == nil {
+			s.notify(EventHealthCheckFailed, appName, containerName, hostAndPort, "")
+		}
+		s.MonitorHealth(container.ID, containerName, hostAndPort, keys, hc, ttl)
 	}
 }
 
 // removeContainer remove a container published by this component
 func (s *Server) removeContainer(event string) {
-	safeMap.RLock()
-	appPath := safeMap.data[event]
-	safeMap.RUnlock()
-
-	if appPath != "" {
-		keyPath := fmt.Sprintf("/deis/services/%s", appPath)
-		log.Printf("stopped %s\n", keyPath)
-		s.removeEtcd(keyPath, false)
+	stopMonitor(event)
+	if info := uncacheContainer(event); info != nil {
+		for keyPath := range info.Keys {
+			log.Printf("stopped %s\n", keyPath)
+			s.removeKey(keyPath)
+		}
+		if info.Published {
+			s.notifyUnpublished(info, "container stopped")
+		}
 	}
+	forgetHealth(event)
 }
 
-// IsPublishableApp determines if the application should be published to etcd.
+// IsPublishableApp determines if the application should be published to the registry. Only
+// the latest version of an application is published, unless it sets canary_weight, in which case
+// older versions stay published and the router splits traffic between them and the latest.
 func (s *Server) IsPublishableApp(name string) bool {
 	r := regexp.MustCompile(appNameRegex)
 	match := r.FindStringSubmatch(name)
@@ -191,10 +236,10 @@ func (s *Server) IsPublishableApp(name string) bool {
 		return false
 	}
 
-	if version >= latestRunningVersion(s.EtcdClient, appName) {
+	if version >= latestRunningVersion(appName) {
 		return true
 	}
-	return false
+	return s.canaryWeight(appName) > 0
 }
 
 // IsPortOpen checks if the given port is accepting tcp connections
@@ -208,54 +253,22 @@ func (s *Server) IsPortOpen(hostAndPort string) bool {
 	return portOpen
 }
 
+// HealthCheckOK performs a single HTTP health check against url after waiting delay seconds,
+// expecting a 200 OK within timeout seconds.
 func (s *Server) HealthCheckOK(url string, delay, timeout int) bool {
 	// sleep for the initial delay
 	time.Sleep(time.Duration(delay) * time.Second)
-	client := http.Client{
-		Timeout: time.Duration(timeout) * time.Second,
+	h := &HealthCheck{
+		Type:      HealthCheckHTTP,
+		Timeout:   time.Duration(timeout) * time.Second,
+		StatusMin: http.StatusOK,
+		StatusMax: http.StatusOK,
 	}
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Printf("an error occurred while performing a health check at %s (%v)\n", url, err)
+	if err := h.probeHTTP(url); err != nil {
+		log.Printf("healthcheck failed for %s (%v)\n", url, err)
 		return false
 	}
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("healthcheck failed for %s (expected %d, got %d)\n", url, http.StatusOK, resp.StatusCode)
-	}
-	return resp.StatusCode == http.StatusOK
-}
-
-// latestRunningVersion retrieves the highest version of the application published
-// to etcd. If no app has been published, returns 0.
-func latestRunningVersion(client *etcd.Client, appName string) int {
-	r := regexp.MustCompile(appNameRegex)
-	if client == nil {
-		// FIXME: client should only be nil during tests. This should be properly refactored.
-		if appName == "ceci-nest-pas-une-app" {
-			return 3
-		}
-		return 0
-	}
-	resp, err := client.Get(fmt.Sprintf("/deis/services/%s", appName), false, true)
-	if err != nil {
-		// no app has been published here (key not found) or there was an error
-		return 0
-	}
-	var versions []int
-	for _, node := range resp.Node.Nodes {
-		match := r.FindStringSubmatch(node.Key)
-		// account for keys that may not be an application container
-		if match == nil {
-			continue
-		}
-		version, err := strconv.Atoi(match[2])
-		if err != nil {
-			log.Println(err)
-			return 0
-		}
-		versions = append(versions, version)
-	}
-	return max(versions)
+	return true
 }
 
 // max returns the maximum value in n
@@ -269,24 +282,21 @@ func max(n []int) int {
 	return val
 }
 
-// getEtcd retrieves the etcd key's value. Returns an empty string if the key was not found.
-func (s *Server) getEtcd(key string) string {
+// getConfig retrieves the config key's value. Returns an empty string if the key was not found.
+func (s *Server) getConfig(key string) string {
 	if s.logLevel == "debug" {
 		log.Println("get", key)
 	}
-	resp, err := s.EtcdClient.Get(key, false, false)
+	value, err := s.Config.Get(key)
 	if err != nil {
-		return ""
-	}
-	if resp != nil && resp.Node != nil {
-		return resp.Node.Value
+		log.Println(err)
 	}
-	return ""
+	return value
 }
 
-// setEtcd sets the corresponding etcd key with the value and ttl
-func (s *Server) setEtcd(key, value string, ttl uint64) {
-	if _, err := s.EtcdClient.Set(key, value, ttl); err != nil {
+// setKey sets the corresponding registry key with the value and ttl
+func (s *Server) setKey(key, value string, ttl time.Duration) {
+	if err := s.Registry.Set(key, value, ttl); err != nil {
 		log.Println(err)
 	}
 	if s.logLevel == "debug" {
@@ -294,9 +304,22 @@ func (s *Server) setEtcd(key, value string, ttl uint64) {
 	}
 }
 
-// removeEtcd removes the corresponding etcd key
-func (s *Server) removeEtcd(key string, recursive bool) {
-	if _, err := s.EtcdClient.Delete(key, recursive); err != nil {
+// listKeys recursively retrieves all registry keys under prefix with their values. Returns an
+// empty map if the prefix was not found.
+func (s *Server) listKeys(prefix string) map[string]string {
+	if s.logLevel == "debug" {
+		log.Println("list", prefix)
+	}
+	keys, err := s.Registry.List(prefix)
+	if err != nil {
+		log.Println(err)
+	}
+	return keys
+}
+
+// removeKey removes the corresponding registry key
+func (s *Server) removeKey(key string) {
+	if err := s.Registry.Delete(key); err != nil {
 		log.Println(err)
 	}
 	if s.logLevel == "debug" {
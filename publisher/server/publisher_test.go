@@ -6,10 +6,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
 )
 
 func TestIsPublishableApp(t *testing.T) {
-	s := &Server{}
+	config := newFakeRegistry(nil)
+	s := &Server{Config: config}
 	appName := "go_v2.web.1"
 	if !s.IsPublishableApp(appName) {
 		t.Errorf("%s should be publishable", appName)
@@ -18,7 +22,10 @@ func TestIsPublishableApp(t *testing.T) {
 	if s.IsPublishableApp(badAppName) {
 		t.Errorf("%s should not be publishable", badAppName)
 	}
-	// publisher assumes that an app name of "test" with a null etcd client has v3 running
+	updateAppVersions(map[string]string{
+		"/deis/services/ceci-nest-pas-une-app/ceci-nest-pas-une-app_v3.web.1": "10.0.0.1:49153",
+	})
+	defer updateAppVersions(map[string]string{})
 	oldVersion := "ceci-nest-pas-une-app_v2.web.1"
 	if s.IsPublishableApp(oldVersion) {
 		t.Errorf("%s should not be publishable", oldVersion)
@@ -31,6 +38,16 @@ func TestIsPublishableApp(t *testing.T) {
 	if !s.IsPublishableApp(futureVersion) {
 		t.Errorf("%s should be publishable", futureVersion)
 	}
+
+	// older versions stay published while the latest is canaried
+	config.Set("/deis/config/ceci-nest-pas-une-app/canary_weight", "10", 0)
+	if !s.IsPublishableApp(oldVersion) {
+		t.Errorf("%s should be publishable while canarying", oldVersion)
+	}
+	config.Set("/deis/config/ceci-nest-pas-une-app/canary_weight", "100", 0)
+	if s.IsPublishableApp(oldVersion) {
+		t.Errorf("%s should not be publishable with an invalid canary_weight", oldVersion)
+	}
 }
 
 func TestIsPortOpen(t *testing.T) {
@@ -70,3 +87,43 @@ func TestHealthCheckOK(t *testing.T) {
 		t.Errorf("healthcheck should be NOT OK")
 	}
 }
+
+func TestPollReconcile(t *testing.T) {
+	fake := newFakeRegistry(map[string]string{
+		"/deis/services/go/go_v2.web.1": "10.0.0.1:49153",
+		"/deis/services/go/go_v2.web.2": "10.0.0.2:49153",
+	})
+	dockerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "[]")
+	}))
+	defer dockerServer.Close()
+	dockerClient, err := docker.NewClient(dockerServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(dockerClient, fake, fake, "10.0.0.1", "error")
+	cacheContainer("gone", &containerInfo{
+		Name: "go_v1.web.1",
+		Keys: map[string]string{"/deis/services/go/go_v1.web.1": "10.0.0.1:49152"},
+	})
+	defer uncacheContainer("gone")
+	defer updateAppVersions(map[string]string{})
+
+	s.Poll(time.Minute)
+	fake.waitFor(t, "delete /deis/services/go/go_v1.web.1")
+	fake.waitFor(t, "delete /deis/services/go/go_v2.web.1")
+	fake.Lock()
+	for _, op := range fake.ops {
+		if op == "delete /deis/services/go/go_v2.web.2" {
+			t.Errorf("keys published by other hosts should not be removed")
+		}
+	}
+	fake.Unlock()
+	if cachedContainer("gone") != nil {
+		t.Errorf("stopped containers should no longer be cached")
+	}
+	if v := latestRunningVersion("go"); v != 2 {
+		t.Errorf("expected go to be at v2, got v%d", v)
+	}
+}
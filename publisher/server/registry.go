@@ -0,0 +1,94 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// Registry is a key/value store the publisher reads application config from and publishes
+// backends to. Keys are absolute paths such as /deis/services/<app>/<container>.
+type Registry interface {
+	// Get returns the value of key, or an empty string if the key does not exist.
+	Get(key string) (string, error)
+	// Set sets the value of key. The key expires after ttl, unless ttl is zero.
+	Set(key, value string, ttl time.Duration) error
+	// Delete removes key.
+	Delete(key string) error
+	// List returns the keys and values found under prefix, such as the backends of an
+	// application under /deis/services/<app>. It returns an empty map if nothing was found.
+	List(prefix string) (map[string]string, error)
+}
+
+// EtcdRegistry is a Registry backed by etcd.
+type EtcdRegistry struct {
+	Client *etcd.Client
+}
+
+// NewEtcdRegistry returns a Registry backed by the etcd client.
+func NewEtcdRegistry(client *etcd.Client) *EtcdRegistry {
+	return &EtcdRegistry{Client: client}
+}
+
+// Get returns the value of key, or an empty string if the key does not exist.
+func (r *EtcdRegistry) Get(key string) (string, error) {
+	resp, err := r.Client.Get(key, false, false)
+	if err != nil {
+		if isEtcdKeyNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if resp != nil && resp.Node != nil {
+		return resp.Node.Value, nil
+	}
+	return "", nil
+}
+
+// Set sets the value of key. The key expires after ttl, unless ttl is zero.
+func (r *EtcdRegistry) Set(key, value string, ttl time.Duration) error {
+	_, err := r.Client.Set(key, value, uint64(ttl.Seconds()))
+	return err
+}
+
+// Delete removes key.
+func (r *EtcdRegistry) Delete(key string) error {
+	_, err := r.Client.Delete(key, false)
+	return err
+}
+
+// List recursively retrieves all keys under prefix with their values.
+func (r *EtcdRegistry) List(prefix string) (map[string]string, error) {
+	keys := make(map[string]string)
+	resp, err := r.Client.Get(prefix, false, true)
+	if err != nil {
+		if isEtcdKeyNotFound(err) {
+			return keys, nil
+		}
+		return keys, err
+	}
+	if resp != nil && resp.Node != nil {
+		flattenNodes(resp.Node.Nodes, keys)
+	}
+	return keys, nil
+}
+
+// flattenNodes adds the key and value of every leaf node to keys.
+func flattenNodes(nodes etcd.Nodes, keys map[string]string) {
+	for _, node := range nodes {
+		if node.Dir {
+			flattenNodes(node.Nodes, keys)
+		} else {
+			keys[node.Key] = node.Value
+		}
+	}
+}
+
+// isEtcdKeyNotFound determines if err is etcd's "Key not found" error.
+func isEtcdKeyNotFound(err error) bool {
+	if etcdErr, ok := err.(*etcd.EtcdError); ok {
+		return etcdErr.ErrorCode == 100
+	}
+	return strings.Contains(err.Error(), "Key not found")
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// opRecorder records the write operations made to a fake, under its lock.
+type opRecorder struct {
+	sync.Mutex
+	ops []string
+}
+
+// waitFor waits for op to be recorded.
+func (r *opRecorder) waitFor(t *testing.T, op string) {
+	for i := 0; i < 100; i++ {
+		r.Lock()
+		for _, o := range r.ops {
+			if o == op {
+				r.Unlock()
+				return
+			}
+		}
+		r.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %q", op)
+}
+
+// fakeRegistry is an in-memory Registry which records the write operations made to it.
+type fakeRegistry struct {
+	opRecorder
+	data map[string]string
+}
+
+func newFakeRegistry(data map[string]string) *fakeRegistry {
+	if data == nil {
+		data = make(map[string]string)
+	}
+	return &fakeRegistry{data: data}
+}
+
+func (f *fakeRegistry) Get(key string) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	return f.data[key], nil
+}
+
+func (f *fakeRegistry) Set(key, value string, ttl time.Duration) error {
+	f.Lock()
+	defer f.Unlock()
+	f.data[key] = value
+	f.ops = append(f.ops, "set "+key)
+	return nil
+}
+
+func (f *fakeRegistry) Delete(key string) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.data, key)
+	f.ops = append(f.ops, "delete "+key)
+	return nil
+}
+
+func (f *fakeRegistry) List(prefix string) (map[string]string, error) {
+	f.Lock()
+	defer f.Unlock()
+	keys := make(map[string]string)
+	for key, value := range f.data {
+		if strings.HasPrefix(key, prefix+"/") {
+			keys[key] = value
+		}
+	}
+	return keys, nil
+}
+
+// fakeEtcd records the write operations sent to the etcd v2 keys API. GET requests are
+// answered from nodes, which holds the JSON encoded node for a key.
+type fakeEtcd struct {
+	opRecorder
+	nodes map[string]string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v2/keys")
+	if r.Method == "GET" {
+		if node, ok := f.nodes[key]; ok {
+			w.Header().Set("X-Etcd-Index", "1")
+			fmt.Fprintf(w, `{"action":"get","node":%s}`, node)
+			return
+		}
+		w.Header().Set("X-Etcd-Index", "1")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"errorCode":100,"message":"Key not found","cause":%q,"index":1}`, key)
+		return
+	}
+	f.Lock()
+	f.ops = append(f.ops, r.Method+" "+key)
+	f.Unlock()
+	w.Header().Set("X-Etcd-Index", "1")
+	fmt.Fprintf(w, `{"action":"set","node":{"key":%q,"modifiedIndex":1,"createdIndex":1}}`, key)
+}
+
+func TestEtcdRegistry(t *testing.T) {
+	fake := &fakeEtcd{nodes: map[string]string{
+		"/deis/config/go/healthcheck_url": `{"key":"/deis/config/go/healthcheck_url","value":"/health"}`,
+		"/deis/services/go": `{"key":"/deis/services/go","dir":true,"nodes":[
+			{"key":"/deis/services/go/go_v2.web.1","value":"10.0.0.1:49153"}]}`,
+	}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+	r := NewEtcdRegistry(etcd.NewClient([]string{ts.URL}))
+
+	if value, err := r.Get("/deis/config/go/healthcheck_url"); err != nil || value != "/health" {
+		t.Errorf("expected /health, got %q (%v)", value, err)
+	}
+	if value, err := r.Get("/deis/config/go/healthcheck_body"); err != nil || value != "" {
+		t.Errorf("expected missing keys to be empty, got %q (%v)", value, err)
+	}
+
+	keys, err := r.List("/deis/services/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys["/deis/services/go/go_v2.web.1"] != "10.0.0.1:49153" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if keys, err := r.List("/deis/services/ruby"); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys, got %v (%v)", keys, err)
+	}
+
+	if err := r.Set("/deis/services/go/go_v2.web.2", "10.0.0.1:49154", 20*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	fake.waitFor(t, "PUT /deis/services/go/go_v2.web.2")
+	if err := r.Delete("/deis/services/go/go_v2.web.2"); err != nil {
+		t.Fatal(err)
+	}
+	fake.waitFor(t, "DELETE /deis/services/go/go_v2.web.2")
+}
+
+func TestFlattenNodes(t *testing.T) {
+	nodes := etcd.Nodes{
+		&etcd.Node{Key: "/deis/ports/go", Dir: true, Nodes: etcd.Nodes{
+			&etcd.Node{Key: "/deis/ports/go/web", Dir: true, Nodes: etcd.Nodes{
+				&etcd.Node{Key: "/deis/ports/go/web/5000", Dir: true, Nodes: etcd.Nodes{
+					&etcd.Node{Key: "/deis/ports/go/web/5000/go_v2.web.1", Value: "10.0.0.1:49153"},
+				}},
+			}},
+		}},
+		&etcd.Node{Key: "/deis/ports/empty", Dir: true},
+	}
+	keys := make(map[string]string)
+	flattenNodes(nodes, keys)
+	if len(keys) != 1 || keys["/deis/ports/go/web/5000/go_v2.web.1"] != "10.0.0.1:49153" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
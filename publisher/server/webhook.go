@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// EventPublished is sent when a container's backends are published.
+	EventPublished = "published"
+	// EventUnpublished is sent when a container's backends are removed.
+	EventUnpublished = "unpublished"
+	// EventHealthCheckFailed is sent when a container reaches its unhealthy threshold.
+	EventHealthCheckFailed = "healthcheck_failed"
+	// EventNoHealthyBackends is sent when an application has no published backends left.
+	EventNoHealthyBackends = "no_healthy_backends"
+
+	webhookURLKey    = "/deis/publisher/webhookURL"
+	webhookSecretKey = "/deis/publisher/webhookSecret"
+
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body, keyed with the
+	// webhook secret.
+	SignatureHeader = "X-Deis-Signature"
+
+	webhookQueueSize = 1000
+)
+
+// Event is the JSON body posted to the webhook.
+type Event struct {
+	Type      string    `json:"type"`
+	App       string    `json:"app"`
+	Container string    `json:"container,omitempty"`
+	Backend   string    `json:"backend,omitempty"`
+	Host      string    `json:"host"`
+	Message   string    `json:"message,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Webhook posts events to the URL configured in /deis/publisher/webhookURL. Events are
+// queued and sent in the background by Run, so publishing is never blocked by the webhook.
+type Webhook struct {
+	Config     Registry
+	Client     *http.Client
+	Retries    int
+	RetryDelay time.Duration
+
+	events chan *Event
+}
+
+// NewWebhook returns a Webhook which reads its URL and secret from config.
+func NewWebhook(config Registry) *Webhook {
+	return &Webhook{
+		Config:     config,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		Retries:    3,
+		RetryDelay: time.Second,
+		events:     make(chan *Event, webhookQueueSize),
+	}
+}
+
+// Notify queues an event to be sent. Events are dropped if the queue is full.
+func (w *Webhook) Notify(e *Event) {
+	select {
+	case w.events <- e:
+	default:
+		log.Printf("webhook queue is full, dropping %s event for %s\n", e.Type, e.App)
+	}
+}
+
+// Run sends queued events until the process exits.
+func (w *Webhook) Run() {
+	for e := range w.events {
+		if err := w.Send(e); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Send posts an event to the webhook, retrying with an exponential backoff on connection and
+// server errors. It does nothing if no webhook URL is configured.
+func (w *Webhook) Send(e *Event) error {
+	url, err := w.Config.Get(webhookURLKey)
+	if err != nil || url == "" {
+		return err
+	}
+	secret, err := w.Config.Get(webhookSecretKey)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	delay := w.RetryDelay
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = w.post(url, secret, body)
+		if !retry || attempt >= w.Retries {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	if err != nil {
+		return fmt.Errorf("could not send %s event for %s to webhook: %v", e.Type, e.App, err)
+	}
+	return nil
+}
+
+// post sends the body to the webhook, returning whether a failed request should be retried.
+func (w *Webhook) post(url, secret string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "deis-publisher")
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body, keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notify sends an event to the webhook, if any.
+func (s *Server) notify(eventType, app, container, backend, message string) {
+	if s.Webhook == nil {
+		return
+	}
+	s.Webhook.Notify(&Event{
+		Type:      eventType,
+		App:       app,
+		Container: container,
+		Backend:   backend,
+		Host:      s.host,
+		Message:   message,
+		Time:      time.Now().UTC(),
+	})
+}
+
+// notifyUnpublished sends an unpublished event for a container to the webhook, followed by a
+// no_healthy_backends event if its application has no backends left.
+func (s *Server) notifyUnpublished(info *containerInfo, message string) {
+	if s.Webhook == nil {
+		return
+	}
+	s.notify(EventUnpublished, info.App, info.Name, info.backend(), message)
+	if isDefaultRoutableType(info.ProcType) && len(s.listKeys(fmt.Sprintf("/deis/services/%s", info.App))) == 0 {
+		s.notify(EventNoHealthyBackends, info.App, "", "", "")
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	if sig := Sign("secret", []byte(`{"type":"published"}`)); len(sig) != 64 {
+		t.Errorf("expected a hex encoded sha256, got %s", sig)
+	}
+	if Sign("secret", []byte("a")) == Sign("other", []byte("a")) {
+		t.Errorf("signatures should depend on the secret")
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var event Event
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			t.Errorf("invalid signature %s", r.Header.Get(SignatureHeader))
+		}
+		json.Unmarshal(body, &event)
+	}))
+	defer ts.Close()
+
+	config := newFakeRegistry(map[string]string{
+		webhookURLKey:    ts.URL,
+		webhookSecretKey: "secret",
+	})
+	w := NewWebhook(config)
+	w.RetryDelay = time.Millisecond
+	if err := w.Send(&Event{Type: EventPublished, App: "go"}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("expected the event to be retried once, got %d requests", requests)
+	}
+	if event.Type != EventPublished || event.App != "go" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestWebhookSendClientError(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	w := NewWebhook(newFakeRegistry(map[string]string{webhookURLKey: ts.URL}))
+	w.RetryDelay = time.Millisecond
+	if err := w.Send(&Event{Type: EventPublished, App: "go"}); err == nil {
+		t.Errorf("expected an error")
+	}
+	if requests != 1 {
+		t.Errorf("client errors should not be retried, got %d requests", requests)
+	}
+}
+
+func TestWebhookSendUnconfigured(t *testing.T) {
+	w := NewWebhook(newFakeRegistry(nil))
+	if err := w.Send(&Event{Type: EventPublished, App: "go"}); err != nil {
+		t.Errorf("expected no error without a webhook URL, got %v", err)
+	}
+}
+
+func TestNotifyUnpublished(t *testing.T) {
+	registry := newFakeRegistry(map[string]string{
+		"/deis/services/go/go_v2.web.2": "10.0.0.2:49153",
+	})
+	s := &Server{Config: registry, Registry: registry, Webhook: NewWebhook(registry), host: "10.0.0.1"}
+	info := &containerInfo{
+		Name:     "go_v2.web.1",
+		App:      "go",
+		ProcType: "web",
+		Keys:     map[string]string{"/deis/services/go/go_v2.web.1": "10.0.0.1:49153"},
+	}
+
+	s.notifyUnpublished(info, "container stopped")
+	e := <-s.Webhook.events
+	if e.Type != EventUnpublished || e.Container != "go_v2.web.1" || e.Backend != "10.0.0.1:49153" || e.Host != "10.0.0.1" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if len(s.Webhook.events) != 0 {
+		t.Errorf("the app still has a backend, got %+v", <-s.Webhook.events)
+	}
+
+	registry.Delete("/deis/services/go/go_v2.web.2")
+	s.notifyUnpublished(info, "container stopped")
+	<-s.Webhook.events
+	if e := <-s.Webhook.events; e.Type != EventNoHealthyBackends || e.App != "go" {
+		t.Errorf("expected a %s event, got %+v", EventNoHealthyBackends, e)
+	}
+}
@@ -0,0 +1,375 @@
+// Package acme implements the parts of the ACME protocol (RFC 8555) needed to obtain
+// certificates for a domain with HTTP-01 challenges, e.g. from Let's Encrypt.
+package acme
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LetsEncryptURL is the directory URL of Let's Encrypt's production ACME server.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// Solver makes the key authorization of an HTTP-01 challenge available at
+// http://<domain>/.well-known/acme-challenge/<token> while the challenge is validated.
+type Solver interface {
+	Present(domain, token, keyAuth string) error
+	CleanUp(domain, token string) error
+}
+
+// Error is a problem document returned by an ACME server.
+type Error struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("acme: %s (%s)", e.Detail, e.Type)
+}
+
+// Client is an ACME client for a single account.
+type Client struct {
+	// DirectoryURL is the URL of the ACME server's directory.
+	DirectoryURL string
+	// Key is the account key.
+	Key        *ecdsa.PrivateKey
+	HTTPClient *http.Client
+	// PollInterval is how often the status of challenges and orders is checked.
+	PollInterval time.Duration
+	// PollTimeout is how long to wait for challenges to be validated and certificates issued.
+	PollTimeout time.Duration
+
+	mu     sync.Mutex
+	dir    *directory
+	kid    string
+	nonces []string
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *Error       `json:"error"`
+}
+
+type authorization struct {
+	Status     string      `json:"status"`
+	Identifier identifier  `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	Error  *Error `json:"error"`
+}
+
+// NewClient returns a client for the account with the given key on the ACME server.
+func NewClient(directoryURL string, key *ecdsa.PrivateKey) *Client {
+	return &Client{
+		DirectoryURL: directoryURL,
+		Key:          key,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		PollInterval: 2 * time.Second,
+		PollTimeout:  2 * time.Minute,
+	}
+}
+
+// Register creates the client's account, agreeing to the server's terms of service, or looks
+// it up if it already exists.
+func (c *Client) Register(email string) error {
+	dir, err := c.directory()
+	if err != nil {
+		return err
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+	resp, err := c.post(dir.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	kid := resp.Header.Get("Location")
+	if kid == "" {
+		return errors.New("acme: no account URL returned")
+	}
+	c.mu.Lock()
+	c.kid = kid
+	c.mu.Unlock()
+	return nil
+}
+
+// Obtain requests a certificate for domain, using solver to answer its HTTP-01 challenge. It
+// returns the PEM encoded certificate chain and private key.
+func (c *Client) Obtain(domain string, solver Solver) (certPEM, keyPEM []byte, err error) {
+	dir, err := c.directory()
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.accountURL() == "" {
+		return nil, nil, errors.New("acme: the account is not registered")
+	}
+
+	var o order
+	resp, err := c.post(dir.NewOrder, map[string]interface{}{
+		"identifiers": []identifier{{Type: "dns", Value: domain}},
+	}, &o)
+	if err != nil {
+		return nil, nil, err
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(authzURL, solver); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := c.post(o.Finalize, map[string]string{"csr": encode(csr)}, &o); err != nil {
+		return nil, nil, err
+	}
+	if err := c.poll(func() (bool, error) {
+		if o.Status == "invalid" {
+			return false, orderError(&o)
+		}
+		if o.Status == "valid" {
+			return true, nil
+		}
+		_, err := c.post(orderURL, nil, &o)
+		return false, err
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	resp, err = c.post(o.Certificate, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// authorize answers the HTTP-01 challenge of an authorization and waits until it is valid.
+func (c *Client) authorize(authzURL string, solver Solver) error {
+	var authz authorization
+	if _, err := c.post(authzURL, nil, &authz); err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	keyAuth, err := c.keyAuthorization(chal.Token)
+	if err != nil {
+		return err
+	}
+	domain := authz.Identifier.Value
+	if err := solver.Present(domain, chal.Token, keyAuth); err != nil {
+		return err
+	}
+	defer solver.CleanUp(domain, chal.Token)
+
+	resp, err := c.post(chal.URL, struct{}{}, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return c.poll(func() (bool, error) {
+		if _, err := c.post(authzURL, nil, &authz); err != nil {
+			return false, err
+		}
+		switch authz.Status {
+		case "valid":
+			return true, nil
+		case "pending", "processing":
+			return false, nil
+		}
+		for _, ch := range authz.Challenges {
+			if ch.Type == "http-01" && ch.Error != nil {
+				return false, ch.Error
+			}
+		}
+		return false, fmt.Errorf("acme: authorization for %s is %s", domain, authz.Status)
+	})
+}
+
+// poll calls check every PollInterval until it is done, fails, or PollTimeout elapses.
+func (c *Client) poll(check func() (bool, error)) error {
+	deadline := time.Now().Add(c.PollTimeout)
+	for {
+		done, err := check()
+		if err != nil || done {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("acme: timed out waiting for the server")
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+func (c *Client) directory() (*directory, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir != nil {
+		return c.dir, nil
+	}
+	resp, err := c.HTTPClient.Get(c.DirectoryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("acme: could not fetch directory: %s", resp.Status)
+	}
+	dir := &directory{}
+	if err := json.NewDecoder(resp.Body).Decode(dir); err != nil {
+		return nil, err
+	}
+	c.dir = dir
+	return dir, nil
+}
+
+func (c *Client) accountURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.kid
+}
+
+// post sends a JWS signed request to url, decoding the JSON response into v if it is not nil.
+// Otherwise the caller must close the response body. A nil payload sends a POST-as-GET
+// request. Requests rejected for a bad nonce are retried once.
+func (c *Client) post(url string, payload interface{}, v interface{}) (*http.Response, error) {
+	var resp *http.Response
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.nonce()
+		if err != nil {
+			return nil, err
+		}
+		body, err := c.sign(url, nonce, payload)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = c.HTTPClient.Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		c.saveNonce(resp)
+		if resp.StatusCode < 400 {
+			break
+		}
+		problem := &Error{Status: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(problem)
+		resp.Body.Close()
+		if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+			continue
+		}
+		if problem.Detail == "" {
+			problem.Detail = resp.Status
+		}
+		return nil, problem
+	}
+	if v != nil {
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) nonce() (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return nonce, nil
+	}
+	c.mu.Unlock()
+
+	dir, err := c.directory()
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.HTTPClient.Head(dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("acme: no nonce returned")
+	}
+	return nonce, nil
+}
+
+func (c *Client) saveNonce(resp *http.Response) {
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, nonce)
+		c.mu.Unlock()
+	}
+}
+
+func orderError(o *order) error {
+	if o.Error != nil {
+		return o.Error
+	}
+	return errors.New("acme: the order is invalid")
+}
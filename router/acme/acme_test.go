@@ -0,0 +1,325 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeACME is an ACME server which validates HTTP-01 challenges by looking up the key
+// authorization presented by the test solver.
+type fakeACME struct {
+	sync.Mutex
+	t        *testing.T
+	url      string
+	nonce    int
+	nonces   map[string]bool
+	accounts map[string]*ecdsa.PublicKey
+	token    string
+	solver   *testSolver
+	domain   string
+	status   string
+	cert     []byte
+	badNonce bool
+}
+
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.nonce++
+	nonce := fmt.Sprintf("nonce-%d", f.nonce)
+	f.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+
+	switch r.URL.Path {
+	case "/directory":
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   f.url + "/nonce",
+			"newAccount": f.url + "/account",
+			"newOrder":   f.url + "/order",
+		})
+		return
+	case "/nonce":
+		return
+	}
+
+	payload, kid, err := f.verify(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		problem := "urn:ietf:params:acme:error:malformed"
+		if strings.Contains(err.Error(), "nonce") {
+			problem = "urn:ietf:params:acme:error:badNonce"
+		}
+		json.NewEncoder(w).Encode(map[string]string{"type": problem, "detail": err.Error()})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", f.url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"status":"valid"}`)
+	case "/order":
+		var o order
+		json.Unmarshal(payload, &o)
+		f.domain = o.Identifiers[0].Value
+		w.Header().Set("Location", f.url+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		f.writeOrder(w)
+	case "/order/1":
+		f.writeOrder(w)
+	case "/authz/1":
+		json.NewEncoder(w).Encode(authorization{
+			Status:     f.status,
+			Identifier: identifier{Type: "dns", Value: f.domain},
+			Challenges: []challenge{
+				{Type: "dns-01", URL: f.url + "/chal/2", Token: "dns"},
+				{Type: "http-01", URL: f.url + "/chal/1", Token: f.token},
+			},
+		})
+	case "/chal/1":
+		thumbprint, _ := Thumbprint(f.accounts[kid])
+		if f.solver.keyAuths[f.token] == f.token+"."+thumbprint {
+			f.status = "valid"
+		} else {
+			f.status = "invalid"
+		}
+		fmt.Fprint(w, `{}`)
+	case "/finalize/1":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || len(csr.DNSNames) != 1 || csr.DNSNames[0] != f.domain {
+			f.t.Errorf("unexpected CSR %v (%v)", csr, err)
+		}
+		f.cert = issue(f.t, csr)
+		f.writeOrder(w)
+	case "/cert/1":
+		w.Write(f.cert)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeACME) writeOrder(w http.ResponseWriter) {
+	o := order{
+		Status:         "pending",
+		Authorizations: []string{f.url + "/authz/1"},
+		Finalize:       f.url + "/finalize/1",
+	}
+	if f.cert != nil {
+		o.Status = "valid"
+		o.Certificate = f.url + "/cert/1"
+	}
+	json.NewEncoder(w).Encode(o)
+}
+
+// verify checks the JWS of a request, returning its payload and the account URL.
+func (f *fakeACME) verify(r *http.Request) ([]byte, string, error) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, "", err
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  *jwk
+	}
+	if err := json.Unmarshal(header, &protected); err != nil {
+		return nil, "", err
+	}
+	if f.badNonce || !f.nonces[protected.Nonce] {
+		f.badNonce = false
+		return nil, "", fmt.Errorf("invalid nonce %q", protected.Nonce)
+	}
+	delete(f.nonces, protected.Nonce)
+	if protected.URL != f.url+r.URL.Path {
+		return nil, "", fmt.Errorf("signed url %s does not match %s", protected.URL, r.URL.Path)
+	}
+
+	var key *ecdsa.PublicKey
+	if protected.JWK != nil {
+		if r.URL.Path != "/account" {
+			return nil, "", fmt.Errorf("jwk used instead of kid")
+		}
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK.Y)
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		protected.Kid = f.url + "/account/1"
+		f.accounts[protected.Kid] = key
+	} else if key = f.accounts[protected.Kid]; key == nil {
+		return nil, "", fmt.Errorf("unknown account %q", protected.Kid)
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	hash := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(key, hash[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, "", fmt.Errorf("invalid signature")
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload, protected.Kid, nil
+}
+
+// issue returns a self-signed certificate for the CSR's key and names.
+func issue(t *testing.T, csr *x509.CertificateRequest) []byte {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	ca := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "fake ca"}}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, csr.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+type testSolver struct {
+	keyAuths map[string]string
+	cleaned  []string
+}
+
+func (s *testSolver) Present(domain, token, keyAuth string) error {
+	s.keyAuths[token] = keyAuth
+	return nil
+}
+
+func (s *testSolver) CleanUp(domain, token string) error {
+	s.cleaned = append(s.cleaned, token)
+	return nil
+}
+
+func newFakeACME(t *testing.T) (*fakeACME, *httptest.Server) {
+	f := &fakeACME{
+		t:        t,
+		nonces:   make(map[string]bool),
+		accounts: make(map[string]*ecdsa.PublicKey),
+		token:    "token-1",
+		status:   "pending",
+		solver:   &testSolver{keyAuths: make(map[string]string)},
+	}
+	ts := httptest.NewServer(f)
+	f.url = ts.URL
+	return f, ts
+}
+
+func TestObtain(t *testing.T) {
+	f, ts := newFakeACME(t)
+	defer ts.Close()
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(ts.URL+"/directory", key)
+	c.PollInterval = time.Millisecond
+	if _, _, err := c.Obtain("www.example.com", f.solver); err == nil {
+		t.Errorf("expected an error for an unregistered account")
+	}
+	if err := c.Register("admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a rejected nonce is retried
+	f.badNonce = true
+	certPEM, keyPEM, err := c.Obtain("www.example.com", f.solver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.solver.cleaned) != 1 || f.solver.cleaned[0] != "token-1" {
+		t.Errorf("expected the challenge to be cleaned up, got %v", f.solver.cleaned)
+	}
+
+	notAfter, err := NotAfter(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notAfter.Before(time.Now().Add(89 * 24 * time.Hour)) {
+		t.Errorf("unexpected expiry %v", notAfter)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		t.Fatalf("expected a PEM encoded RSA key, got %s", keyPEM)
+	}
+	certKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(mustDecode(t, certPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); !ok || pub.N.Cmp(certKey.N) != 0 {
+		t.Errorf("the certificate does not match the key")
+	}
+}
+
+func TestObtainInvalidChallenge(t *testing.T) {
+	f, ts := newFakeACME(t)
+	defer ts.Close()
+
+	key, _ := GenerateKey()
+	c := NewClient(ts.URL+"/directory", key)
+	c.PollInterval = time.Millisecond
+	if err := c.Register(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Obtain("www.example.com", &wrongSolver{f.solver}); err == nil {
+		t.Errorf("expected the challenge to fail")
+	}
+}
+
+// wrongSolver presents an invalid key authorization.
+type wrongSolver struct {
+	*testSolver
+}
+
+func (s *wrongSolver) Present(domain, token, keyAuth string) error {
+	return s.testSolver.Present(domain, token, "wrong")
+}
+
+func TestKeyRoundTrip(t *testing.T) {
+	key, _ := GenerateKey()
+	data, err := MarshalKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.D.Cmp(key.D) != 0 {
+		t.Errorf("parsed key does not match")
+	}
+	if _, err := ParseKey([]byte("garbage")); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func mustDecode(t *testing.T, data []byte) []byte {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no PEM data in %s", data)
+	}
+	return block.Bytes
+}
@@ -0,0 +1,137 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// jwk is the JSON web key of a P-256 account key. Its fields are in lexicographic order, as
+// required to compute its thumbprint.
+type jwk struct {
+	Crv string `json:"crv"`
+	Kty string `json:"kty"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJWK(key *ecdsa.PublicKey) *jwk {
+	return &jwk{
+		Crv: "P-256",
+		Kty: "EC",
+		X:   encode(pad(key.X.Bytes(), 32)),
+		Y:   encode(pad(key.Y.Bytes(), 32)),
+	}
+}
+
+// sign returns the flattened JWS of payload for a request to url. Requests are signed with
+// the account URL once the account is registered, and with the account key's JWK until then.
+func (c *Client) sign(url, nonce string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": nonce,
+		"url":   url,
+	}
+	if kid := c.accountURL(); kid != "" {
+		protected["kid"] = kid
+	} else {
+		protected["jwk"] = newJWK(&c.Key.PublicKey)
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	// a POST-as-GET request has an empty payload
+	var body []byte
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	signingInput := encode(header) + "." + encode(body)
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.Key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{
+		"protected": encode(header),
+		"payload":   encode(body),
+		"signature": encode(append(pad(r.Bytes(), 32), pad(s.Bytes(), 32)...)),
+	})
+}
+
+// keyAuthorization returns the key authorization of a challenge token.
+func (c *Client) keyAuthorization(token string) (string, error) {
+	thumbprint, err := Thumbprint(&c.Key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + thumbprint, nil
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of an account key.
+func Thumbprint(key *ecdsa.PublicKey) (string, error) {
+	b, err := json.Marshal(newJWK(key))
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return encode(hash[:]), nil
+}
+
+// GenerateKey returns a new account key.
+func GenerateKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// MarshalKey PEM encodes an account key.
+func MarshalKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseKey parses a PEM encoded account key.
+func ParseKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("acme: no PEM encoded key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// NotAfter returns the expiry of the first certificate of a PEM encoded chain.
+func NotAfter(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, errors.New("acme: no PEM encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pad left pads b with zeros to size bytes.
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+
+	dtime "github.com/deis/deis/pkg/time"
+)
+
+// accessLogRegex matches the upstreaminfo log_format defined in nginx.conf:
+//
+//	[$time_local] - $remote_addr - $remote_user - $status - "$request" - $bytes_sent -
+//	"$http_referer" - "$http_user_agent" - "$server_name" - $upstream_addr - $http_host -
+//	$upstream_response_time - $request_time - $deis_app
+var accessLogRegex = regexp.MustCompile(`^\[([^\]]+)\] - (\S+) - (\S+) - (\d{3}) - "([^"]*)" - (\d+) - "([^"]*)" - "([^"]*)" - "([^"]*)" - (.+?) - (\S+) - (.+?) - (\S+) - (\S+)$`)
+
+// accessLogEntry is a parsed line of the nginx access log.
+type accessLogEntry struct {
+	// App is the application which served the request, or empty if it was not proxied to an
+	// application.
+	App          string
+	Host         string
+	ClientIP     string
+	Method       string
+	Path         string
+	Status       int
+	Bytes        int64
+	Upstream     string
+	UpstreamTime float64
+	RequestTime  float64
+}
+
+// parseAccessLog parses a line of the nginx access log.
+func parseAccessLog(line string) (*accessLogEntry, error) {
+	match := accessLogRegex.FindStringSubmatch(line)
+	if match == nil {
+		return nil, fmt.Errorf("unrecognized access log line: %s", line)
+	}
+	e := &accessLogEntry{
+		ClientIP: match[2],
+		Upstream: dashToEmpty(match[10]),
+		Host:     dashToEmpty(match[11]),
+		App:      dashToEmpty(match[14]),
+	}
+	e.Status, _ = strconv.Atoi(match[4])
+	e.Bytes, _ = strconv.ParseInt(match[6], 10, 64)
+	if request := strings.Fields(match[5]); len(request) >= 2 {
+		e.Method, e.Path = request[0], request[1]
+	}
+	e.UpstreamTime = upstreamTime(match[12])
+	e.RequestTime, _ = strconv.ParseFloat(match[13], 64)
+	return e, nil
+}
+
+// String formats the entry as key=value pairs for the application's logs.
+func (e *accessLogEntry) String() string {
+	return fmt.Sprintf("method=%s path=%q host=%s fwd=%q status=%d bytes=%d upstream=%s upstream_time=%.3fs request_time=%.3fs",
+		e.Method, e.Path, e.Host, e.ClientIP, e.Status, e.Bytes, e.Upstream, e.UpstreamTime, e.RequestTime)
+}
+
+// upstreamTime sums the response times of every upstream nginx tried, e.g. "0.002, 0.010".
+func upstreamTime(value string) float64 {
+	var total float64
+	for _, t := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == ':' }) {
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			total += f
+		}
+	}
+	return total
+}
+
+func dashToEmpty(value string) string {
+	if value == "-" {
+		return ""
+	}
+	return value
+}
+
+// logShipper sends parsed access logs of applications to the Deis logger, so they show up in
+// deis logs. It is enabled by setting /deis/router/shipAccessLogs to true.
+type logShipper struct {
+	client *etcd.Client
+
+	mu      sync.RWMutex
+	enabled bool
+	addr    string
+}
+
+// watch refreshes the shipper's settings from etcd until the process exits.
+func (s *logShipper) watch() {
+	for {
+		enabled := getEtcd(s.client, "/deis/router/shipAccessLogs") == "true"
+		host, port := getEtcd(s.client, "/deis/logs/host"), getEtcd(s.client, "/deis/logs/port")
+		s.mu.Lock()
+		s.enabled = enabled && host != "" && port != ""
+		s.addr = net.JoinHostPort(host, port)
+		s.mu.Unlock()
+		time.Sleep(timeout)
+	}
+}
+
+// ship sends the entry to the logger in the format it expects from deis-logspout.
+func (s *logShipper) ship(e *accessLogEntry) {
+	s.mu.RLock()
+	enabled, addr := s.enabled, s.addr
+	s.mu.RUnlock()
+	if !enabled || e.App == "" {
+		return
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Debugf("could not ship access log: %v", err)
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s %s[deis-router]: %s", time.Now().Format(dtime.DeisDatetimeFormat), e.App, e)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseAccessLog(t *testing.T) {
+	line := `[19/Oct/2026:10:12:01 +0000] - 10.0.0.9 - - - 502 - "GET /static/app.js HTTP/1.1" - 5120 - "-" - "curl/7.43.0" - "~^go\.(?<domain>.+)$" - 10.0.0.1:49153, 10.0.0.2:49153 - go.example.com - 0.004, 0.010 - 0.015 - go`
+	e, err := parseAccessLog(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := accessLogEntry{
+		App:          "go",
+		Host:         "go.example.com",
+		ClientIP:     "10.0.0.9",
+		Method:       "GET",
+		Path:         "/static/app.js",
+		Status:       502,
+		Bytes:        5120,
+		Upstream:     "10.0.0.1:49153, 10.0.0.2:49153",
+		UpstreamTime: 0.014,
+		RequestTime:  0.015,
+	}
+	if *e != expected {
+		t.Errorf("expected %+v, got %+v", expected, *e)
+	}
+}
+
+func TestParseAccessLogWithoutApp(t *testing.T) {
+	line := `[19/Oct/2026:10:12:01 +0000] - 10.0.0.9 - - - 200 - "GET /health-check HTTP/1.1" - 151 - "-" - "ELB-HealthChecker/1.0" - "" - - - 10.0.0.1:9090 - - - 0.000 - -`
+	e, err := parseAccessLog(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.App != "" || e.Upstream != "" || e.UpstreamTime != 0 {
+		t.Errorf("expected a request which was not proxied, got %+v", *e)
+	}
+
+	if _, err := parseAccessLog("2026/10/19 10:12:01 [error] 12#0: something"); err == nil {
+		t.Errorf("expected an error for an unrecognized line")
+	}
+}
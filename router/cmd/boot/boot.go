@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,6 +20,9 @@ import (
 
 var log = logrus.New()
 
+// nginxUp is 1 while nginx is accepting connections.
+var nginxUp int32
+
 const (
 	timeout        time.Duration = 10 * time.Second
 	ttl            time.Duration = timeout * 2
@@ -44,8 +47,17 @@ func main() {
 
 	hostEtcdPath := getopt("HOST_ETCD_PATH", "/deis/router/hosts/"+host)
 
+	statusEtcdPath := getopt("STATUS_ETCD_PATH", "/deis/router/status/"+host)
+
 	externalPort := getopt("EXTERNAL_PORT", "80")
 
+	metricsAddr := getopt("METRICS_ADDR", "127.0.0.1:9091")
+
+	shutdownTimeout, err := time.ParseDuration(getopt("SHUTDOWN_TIMEOUT", "30") + "s")
+	if err != nil {
+		log.Fatalf("invalid SHUTDOWN_TIMEOUT: %v", err)
+	}
+
 	client := etcd.NewClient([]string{"http://" + host + ":" + etcdPort})
 
 	// wait until etcd has discarded potentially stale values
@@ -55,6 +67,8 @@ func main() {
 	mkdirEtcd(client, "/deis/config")
 	mkdirEtcd(client, "/deis/controller")
 	mkdirEtcd(client, "/deis/services")
+	mkdirEtcd(client, "/deis/ports")
+	mkdirEtcd(client, "/deis/draining")
 	mkdirEtcd(client, "/deis/domains")
 	mkdirEtcd(client, "/deis/builder")
 	mkdirEtcd(client, "/deis/certs")
@@ -65,99 +79,94 @@ func main() {
 
 	log.Info("Starting Nginx...")
 
-	go tailFile(nginxAccessLog)
+	requestMetrics := newMetrics()
+	shipper := &logShipper{client: client}
+	go shipper.watch()
+	go tailAccessLog(nginxAccessLog, requestMetrics, shipper)
 	go tailFile(nginxErrorLog)
+	go serveMetrics(metricsAddr, requestMetrics)
 
-	nginxChan := make(chan bool)
-	go launchNginx(nginxChan)
-	<-nginxChan
-
-	// FIXME: have to launch cron first so generate-certs will generate the files nginx requires
-	go launchCron()
-
-	waitForInitialConfd(host+":"+etcdPort, timeout)
-
-	go launchConfd(host + ":" + etcdPort)
-
-	go publishService(client, hostEtcdPath, host, externalPort, uint64(ttl.Seconds()))
-
-	log.Info("deis-router running...")
+	supervisor := newSupervisor()
 
 	exitChan := make(chan os.Signal, 2)
 	signal.Notify(exitChan, syscall.SIGTERM, syscall.SIGINT)
-	<-exitChan
-	tail.Cleanup()
-}
 
-func launchCron() {
-	// edit crontab
-	crontab := `(echo "* * * * * generate-certs >> /dev/stdout") | crontab -`
-	cmd := exec.Command("bash", "-c", crontab)
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("could not write to crontab: %v", err)
+	nginxChan := make(chan bool, 1)
+	go supervisor.supervise(nginxProcess(nginxChan))
+	select {
+	case <-nginxChan:
+	case err := <-supervisor.failed:
+		log.Fatal(err)
 	}
 
-	// run cron
-	cmd = exec.Command("crond")
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("cron terminated by error: %v", err)
-	}
-}
+	reloader := newConfigReloader(func(status string) {
+		setDefaultEtcd(client, statusEtcdPath, status)
+	})
+	renderer := newTemplateRenderer(client, reloader)
+	renderer.watch()
+	renderer.renderUntilValid()
+	go renderer.run()
 
-// Wait until the compilation of the templates
-func waitForInitialConfd(etcd string, timeout time.Duration) {
-	for {
-		var buffer bytes.Buffer
-		output := bufio.NewWriter(&buffer)
-		log.Debugf("Connecting to etcd server %s", etcd)
-		cmd := exec.Command("confd", "-node", etcd, "-onetime", "--log-level", "error")
-		cmd.Stdout = output
-		cmd.Stderr = output
-
-		err := cmd.Run()
-		output.Flush()
-		if err == nil {
-			break
-		}
+	go serveACMEChallenges(client)
+	go newCertManager(client, host, getopt("ACME_INSECURE", "false") == "true").run()
 
-		log.Info("waiting for confd to write initial templates...")
-		log.Debugf("\n%s", buffer.String())
-		time.Sleep(timeout)
-	}
-}
+	stopPublishing := make(chan chan struct{})
+	go publishService(client, hostEtcdPath, host, externalPort, uint64(ttl.Seconds()), stopPublishing)
 
-func launchConfd(etcd string) {
-	cmd := exec.Command("confd", "-node", etcd, "--log-level", "error", "--interval", "5")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	log.Info("deis-router running...")
 
-	if err := cmd.Start(); err != nil {
-		log.Warn("confd terminated by error: %v", err)
+	select {
+	case <-exitChan:
+		log.Info("shutting down deis-router...")
+		// stop routing traffic to this router before nginx stops accepting connections
+		unpublishService(stopPublishing)
+		// nginx finishes serving open requests on SIGQUIT
+		supervisor.stop("nginx", syscall.SIGQUIT, shutdownTimeout)
+		renderer.stopWatching()
+		tail.Cleanup()
+	case err := <-supervisor.failed:
+		log.Error(err)
+		// stop routing traffic to this router right away instead of waiting for the ttl
+		unpublishService(stopPublishing)
+		tail.Cleanup()
+		os.Exit(1)
 	}
 }
 
-func launchNginx(nginxChan chan bool) {
-	cmd := exec.Command("/opt/nginx/sbin/nginx", "-c", "/opt/nginx/conf/nginx.conf")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Start(); err != nil {
-		log.Warn("Nginx terminated by error: %v", err)
-	}
-
-	// Wait until the nginx is available
-	for {
-		_, err := net.DialTimeout("tcp", "127.0.0.1:80", timeout)
-		if err == nil {
-			nginxChan <- true
-			break
-		}
-	}
-
-	if err := cmd.Wait(); err != nil {
-		log.Warnf("Nginx terminated by error: %v", err)
-	} else {
-		log.Info("Reloading nginx (change in configuration)...")
+// nginxProcess returns nginx as a supervised process. nginxChan receives a value the first
+// time nginx accepts connections.
+func nginxProcess(nginxChan chan bool) *process {
+	return &process{
+		name: "nginx",
+		command: func() *exec.Cmd {
+			cmd := exec.Command(nginxBinary, "-c", nginxConf)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			return cmd
+		},
+		started: func(done <-chan struct{}) {
+			// Wait until the nginx is available
+			for {
+				conn, err := net.DialTimeout("tcp", "127.0.0.1:80", timeout)
+				if err == nil {
+					conn.Close()
+					break
+				}
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Second):
+				}
+			}
+			atomic.StoreInt32(&nginxUp, 1)
+			select {
+			case nginxChan <- true:
+			default:
+			}
+		},
+		stopped: func() {
+			atomic.StoreInt32(&nginxUp, 0)
+		},
 	}
 }
 
@@ -177,19 +186,67 @@ func tailFile(path string) {
 	}
 }
 
+// tailAccessLog logs every line of the nginx access log, recording metrics for the requests
+// of applications and shipping them to the logger.
+func tailAccessLog(path string, m *metrics, shipper *logShipper) {
+	mkfifo(path)
+	t, _ := tail.TailFile(path, tail.Config{Follow: true})
+
+	for line := range t.Lines {
+		log.Info(line.Text)
+		entry, err := parseAccessLog(line.Text)
+		if err != nil {
+			log.Debug(err)
+			continue
+		}
+		m.record(entry)
+		shipper.ship(entry)
+	}
+}
+
+func serveMetrics(addr string, m *metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Warnf("metrics endpoint terminated by error: %v", err)
+	}
+}
+
 func publishService(
 	client *etcd.Client,
 	etcdPath string,
 	host string,
 	externalPort string,
-	ttl uint64) {
+	ttl uint64,
+	stop chan chan struct{}) {
 
+	published := false
 	for {
-		setEtcd(client, etcdPath, host+":"+externalPort, ttl)
-		time.Sleep(timeout)
+		if atomic.LoadInt32(&nginxUp) == 1 {
+			setEtcd(client, etcdPath, host+":"+externalPort, ttl)
+			published = true
+		} else if published {
+			log.Warn("nginx is down, unpublishing router")
+			deleteEtcd(client, etcdPath)
+			published = false
+		}
+		select {
+		case done := <-stop:
+			deleteEtcd(client, etcdPath)
+			close(done)
+			return
+		case <-time.After(timeout):
+		}
 	}
 }
 
+// unpublishService stops publishService and waits until the router's key has been removed.
+func unpublishService(stop chan chan struct{}) {
+	done := make(chan struct{})
+	stop <- done
+	<-done
+}
+
 func setEtcd(client *etcd.Client, key, value string, ttl uint64) {
 	_, err := client.Set(key, value, ttl)
 	if err != nil {
@@ -197,6 +254,24 @@ func setEtcd(client *etcd.Client, key, value string, ttl uint64) {
 	}
 }
 
+func deleteEtcd(client *etcd.Client, key string) {
+	_, err := client.Delete(key, false)
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		log.Warn(err)
+	}
+}
+
+func getEtcd(client *etcd.Client, key string) string {
+	resp, err := client.Get(key, false, false)
+	if err != nil {
+		if !strings.Contains(err.Error(), "Key not found") {
+			log.Warn(err)
+		}
+		return ""
+	}
+	return resp.Node.Value
+}
+
 func setDefaultEtcd(client *etcd.Client, key, value string) {
 	_, err := client.Set(key, value, 0)
 	if err != nil {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+
+	"github.com/deis/deis/router/acme"
+)
+
+const (
+	// acmeChallengeAddr is where nginx proxies /.well-known/acme-challenge/ requests of custom
+	// domains to.
+	acmeChallengeAddr string = "127.0.0.1:9092"
+	acmeChallengePath string = "/.well-known/acme-challenge/"
+	acmeEtcdPath      string = "/deis/router/acme"
+	// acmeCheckInterval is how often custom domains are checked for missing or expiring certs.
+	acmeCheckInterval time.Duration = 5 * time.Minute
+	// acmeRenewBefore is how long before expiry certificates are renewed.
+	acmeRenewBefore time.Duration = 30 * 24 * time.Hour
+	// acmeLockTTL bounds how long a router may hold the lock while issuing certificates.
+	acmeLockTTL time.Duration = 30 * time.Minute
+)
+
+// certManager issues certificates with ACME for the custom domains found under /deis/domains,
+// stores them under /deis/certs and renews them before they expire. It is enabled by setting
+// /deis/router/acme/enabled to true.
+//
+// Every router runs a certManager, but only the one holding the lock in etcd issues
+// certificates at a time. Challenges are stored in etcd, so any router can answer them.
+type certManager struct {
+	client     *etcd.Client
+	host       string
+	httpClient *http.Client
+}
+
+func newCertManager(client *etcd.Client, host string, insecure bool) *certManager {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	if insecure {
+		// only meant for testing against a local ACME server such as pebble
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	return &certManager{client: client, host: host, httpClient: httpClient}
+}
+
+// run checks the certificates of custom domains until the process exits.
+func (m *certManager) run() {
+	for {
+		if getEtcd(m.client, acmeEtcdPath+"/enabled") == "true" {
+			m.check()
+		}
+		time.Sleep(acmeCheckInterval)
+	}
+}
+
+// check issues certificates for the custom domains which need one, if no other router is.
+func (m *certManager) check() {
+	lock := acmeEtcdPath + "/lock"
+	if _, err := m.client.Create(lock, m.host, uint64(acmeLockTTL.Seconds())); err != nil {
+		if !strings.Contains(err.Error(), "Key already exists") {
+			log.Warn(err)
+		}
+		return
+	}
+	defer m.client.CompareAndDelete(lock, m.host, 0)
+
+	var domains []string
+	for _, domain := range lsEtcd(m.client, "/deis/domains") {
+		// wildcard domains cannot be validated with HTTP-01 challenges
+		if !strings.Contains(domain, "*") && m.needsCert(domain) {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return
+	}
+
+	client, err := m.acmeClient()
+	if err != nil {
+		log.Warnf("could not register with the ACME server: %v", err)
+		return
+	}
+	for _, domain := range domains {
+		log.Infof("requesting a certificate for %s...", domain)
+		cert, key, err := client.Obtain(domain, &etcdSolver{client: m.client})
+		if err != nil {
+			log.Warnf("could not obtain a certificate for %s: %v", domain, err)
+			continue
+		}
+		setDefaultEtcd(m.client, "/deis/certs/"+domain+"/cert", string(cert))
+		setDefaultEtcd(m.client, "/deis/certs/"+domain+"/key", string(key))
+		setDefaultEtcd(m.client, "/deis/certs/"+domain+"/acme", "true")
+		log.Infof("installed a certificate for %s", domain)
+	}
+}
+
+// needsCert determines whether a domain has no certificate, or has one issued with ACME which
+// expires soon. Certificates added with deis certs:add are never replaced.
+func (m *certManager) needsCert(domain string) bool {
+	cert := getEtcd(m.client, "/deis/certs/"+domain+"/cert")
+	if cert == "" {
+		return true
+	}
+	if getEtcd(m.client, "/deis/certs/"+domain+"/acme") != "true" {
+		return false
+	}
+	notAfter, err := acme.NotAfter([]byte(cert))
+	if err != nil {
+		log.Warnf("could not parse the certificate of %s: %v", domain, err)
+		return true
+	}
+	return time.Now().Add(acmeRenewBefore).After(notAfter)
+}
+
+// acmeClient returns a client for the ACME account shared by all routers, creating the account
+// key on first use.
+func (m *certManager) acmeClient() (*acme.Client, error) {
+	key, err := acme.ParseKey([]byte(getEtcd(m.client, acmeEtcdPath+"/accountKey")))
+	if err != nil {
+		if key, err = acme.GenerateKey(); err != nil {
+			return nil, err
+		}
+		data, err := acme.MarshalKey(key)
+		if err != nil {
+			return nil, err
+		}
+		setDefaultEtcd(m.client, acmeEtcdPath+"/accountKey", string(data))
+	}
+
+	directory := getEtcd(m.client, acmeEtcdPath+"/directory")
+	if directory == "" {
+		directory = acme.LetsEncryptURL
+	}
+	client := acme.NewClient(directory, key)
+	client.HTTPClient = m.httpClient
+	return client, client.Register(getEtcd(m.client, acmeEtcdPath+"/email"))
+}
+
+// etcdSolver stores the key authorizations of HTTP-01 challenges in etcd.
+type etcdSolver struct {
+	client *etcd.Client
+}
+
+func (s *etcdSolver) Present(domain, token, keyAuth string) error {
+	_, err := s.client.Set(acmeEtcdPath+"/challenges/"+token, keyAuth, uint64(time.Hour.Seconds()))
+	return err
+}
+
+func (s *etcdSolver) CleanUp(domain, token string) error {
+	_, err := s.client.Delete(acmeEtcdPath+"/challenges/"+token, false)
+	return err
+}
+
+// serveACMEChallenges answers the HTTP-01 challenges stored in etcd by any router.
+func serveACMEChallenges(client *etcd.Client) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(acmeChallengePath, func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, acmeChallengePath)
+		keyAuth := ""
+		if token != "" && !strings.Contains(token, "/") {
+			keyAuth = getEtcd(client, acmeEtcdPath+"/challenges/"+token)
+		}
+		if keyAuth == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+	if err := http.ListenAndServe(acmeChallengeAddr, mux); err != nil {
+		log.Warnf("ACME challenge server terminated by error: %v", err)
+	}
+}
+
+// lsEtcd returns the names of the keys in an etcd directory.
+func lsEtcd(client *etcd.Client, dir string) []string {
+	resp, err := client.Get(dir, false, false)
+	if err != nil {
+		if !strings.Contains(err.Error(), "Key not found") {
+			log.Warn(err)
+		}
+		return nil
+	}
+	var names []string
+	for _, node := range resp.Node.Nodes {
+		names = append(names, path.Base(node.Key))
+	}
+	return names
+}
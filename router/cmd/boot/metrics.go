@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram buckets.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// rateWindow is the number of seconds request and error rates are averaged over.
+const rateWindow = 60
+
+// appMetrics are the request metrics of an application.
+type appMetrics struct {
+	Requests    uint64  `json:"requests"`
+	Errors      uint64  `json:"errors"`
+	RequestRate float64 `json:"requestRate"`
+	ErrorRate   float64 `json:"errorRate"`
+	Latency     latency `json:"latency"`
+
+	// per second counts of the last rateWindow seconds, indexed by unix time modulo rateWindow
+	seconds  [rateWindow]int64
+	requests [rateWindow]uint64
+	errors   [rateWindow]uint64
+}
+
+// latency is a cumulative histogram of request times, in seconds.
+type latency struct {
+	Buckets []bucket `json:"buckets"`
+	Count   uint64   `json:"count"`
+	Sum     float64  `json:"sum"`
+}
+
+type bucket struct {
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// metrics holds the request metrics of every application seen in the access log.
+type metrics struct {
+	sync.Mutex
+	apps map[string]*appMetrics
+	now  func() time.Time
+}
+
+func newMetrics() *metrics {
+	return &metrics{apps: make(map[string]*appMetrics), now: time.Now}
+}
+
+// record adds a request to its application's metrics. 5xx responses count as errors.
+func (m *metrics) record(e *accessLogEntry) {
+	if e.App == "" {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	a, ok := m.apps[e.App]
+	if !ok {
+		a = &appMetrics{Latency: latency{Buckets: make([]bucket, len(latencyBuckets))}}
+		for i, le := range latencyBuckets {
+			a.Latency.Buckets[i].LE = le
+		}
+		m.apps[e.App] = a
+	}
+
+	now := m.now().Unix()
+	i := now % rateWindow
+	if a.seconds[i] != now {
+		a.seconds[i], a.requests[i], a.errors[i] = now, 0, 0
+	}
+	a.Requests++
+	a.requests[i]++
+	if e.Status >= 500 {
+		a.Errors++
+		a.errors[i]++
+	}
+
+	a.Latency.Count++
+	a.Latency.Sum += e.RequestTime
+	for i := range a.Latency.Buckets {
+		if e.RequestTime <= a.Latency.Buckets[i].LE {
+			a.Latency.Buckets[i].Count++
+		}
+	}
+}
+
+// snapshot returns a copy of every application's metrics with up to date rates.
+func (m *metrics) snapshot() map[string]appMetrics {
+	m.Lock()
+	defer m.Unlock()
+	now := m.now().Unix()
+	apps := make(map[string]appMetrics, len(m.apps))
+	for name, a := range m.apps {
+		var requests, errors uint64
+		for i := range a.seconds {
+			if now-a.seconds[i] < rateWindow {
+				requests += a.requests[i]
+				errors += a.errors[i]
+			}
+		}
+		s := *a
+		s.RequestRate = float64(requests) / rateWindow
+		s.ErrorRate = float64(errors) / rateWindow
+		s.Latency.Buckets = append([]bucket(nil), a.Latency.Buckets...)
+		apps[name] = s
+	}
+	return apps
+}
+
+// ServeHTTP writes the metrics of every application as JSON.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.snapshot())
+}
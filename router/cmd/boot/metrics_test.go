@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	m := newMetrics()
+	m.now = func() time.Time { return now }
+
+	m.record(&accessLogEntry{App: "go", Status: 200, RequestTime: 0.003})
+	m.record(&accessLogEntry{App: "go", Status: 503, RequestTime: 0.2})
+	m.record(&accessLogEntry{Status: 200, RequestTime: 0.001})
+	now = now.Add(time.Second)
+	m.record(&accessLogEntry{App: "go", Status: 200, RequestTime: 3})
+
+	apps := m.snapshot()
+	if len(apps) != 1 {
+		t.Fatalf("expected metrics for a single app, got %v", apps)
+	}
+	a := apps["go"]
+	if a.Requests != 3 || a.Errors != 1 {
+		t.Errorf("expected 3 requests and 1 error, got %d and %d", a.Requests, a.Errors)
+	}
+	if a.RequestRate != 3.0/rateWindow || a.ErrorRate != 1.0/rateWindow {
+		t.Errorf("unexpected rates %f and %f", a.RequestRate, a.ErrorRate)
+	}
+	for _, b := range a.Latency.Buckets {
+		var expected uint64
+		switch {
+		case b.LE >= 3:
+			expected = 3
+		case b.LE >= 0.2:
+			expected = 2
+		case b.LE >= 0.003:
+			expected = 1
+		}
+		if b.Count != expected {
+			t.Errorf("expected %d requests under %fs, got %d", expected, b.LE, b.Count)
+		}
+	}
+
+	// requests older than the rate window no longer count towards the rates
+	now = now.Add(rateWindow * time.Second)
+	if a := m.snapshot()["go"]; a.RequestRate != 0 || a.Requests != 3 {
+		t.Errorf("expected no recent requests, got %+v", a)
+	}
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, req)
+	var body map[string]appMetrics
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["go"].Requests != 3 {
+		t.Errorf("unexpected metrics %v", body)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	nginxBinary string = "/opt/nginx/sbin/nginx"
+	nginxConf   string = "/opt/nginx/conf/nginx.conf"
+	// lastGoodDir holds a copy of the last config nginx accepted.
+	lastGoodDir string = "/opt/nginx/last-good"
+	// configOK is written to the router's status key while its config is valid.
+	configOK string = "ok"
+)
+
+// nginxConfigFiles are the files loaded by nginx.
+var nginxConfigFiles = []string{
+	nginxConf,
+	"/opt/nginx/conf/deis.conf",
+	"/opt/nginx/conf/ssl.conf",
+	"/etc/ssl/deis.cert",
+	"/etc/ssl/deis.key",
+	"/etc/ssl/dhparam.pem",
+}
+
+// configReloader validates rendered config before nginx is reloaded with it, rolling
+// back to the last config nginx accepted when it is invalid.
+type configReloader struct {
+	nginx       string
+	conf        string
+	files       []string
+	lastGoodDir string
+	// setStatus reports configOK or the validation error.
+	setStatus func(status string)
+}
+
+func newConfigReloader(setStatus func(string)) *configReloader {
+	return &configReloader{
+		nginx:       nginxBinary,
+		conf:        nginxConf,
+		files:       nginxConfigFiles,
+		lastGoodDir: lastGoodDir,
+		setStatus:   setStatus,
+	}
+}
+
+// reload validates the rendered config and reloads nginx with it. If the config is invalid, the
+// last good config is restored and nginx is left untouched.
+func (r *configReloader) reload() error {
+	if err := r.validate(); err != nil {
+		r.setStatus(err.Error())
+		if restoreErr := r.restore(); restoreErr != nil {
+			log.Warnf("could not restore the last good config: %v", restoreErr)
+		}
+		return err
+	}
+	if err := r.save(); err != nil {
+		log.Warnf("could not save the last good config: %v", err)
+	}
+	r.setStatus(configOK)
+	if out, err := exec.Command(r.nginx, "-s", "reload").CombinedOutput(); err != nil {
+		return fmt.Errorf("could not reload nginx: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// validate runs nginx -t against the rendered config.
+func (r *configReloader) validate() error {
+	out, err := exec.Command(r.nginx, "-t", "-c", r.conf).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("invalid nginx config: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// save copies the rendered config to the last good config.
+func (r *configReloader) save() error {
+	for _, path := range r.files {
+		if err := copyFile(path, filepath.Join(r.lastGoodDir, path)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore copies the last good config over the rendered config, if there is one.
+func (r *configReloader) restore() error {
+	for _, path := range r.files {
+		if err := copyFile(filepath.Join(r.lastGoodDir, path), path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile atomically replaces dest with a copy of src.
+func copyFile(src, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dest, data, info.Mode())
+}
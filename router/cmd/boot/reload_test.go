@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeNginx rejects any config containing "bad".
+const fakeNginx = `#!/bin/sh
+if [ "$1" = "-t" ] && grep -q bad "$3"; then
+	echo "unknown directive \"bad\""
+	exit 1
+fi
+`
+
+func newTestReloader(t *testing.T) (*configReloader, *string, func()) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nginx := filepath.Join(dir, "nginx")
+	if err := ioutil.WriteFile(nginx, []byte(fakeNginx), 0755); err != nil {
+		t.Fatal(err)
+	}
+	conf := filepath.Join(dir, "conf", "nginx.conf")
+	status := new(string)
+	r := &configReloader{
+		nginx:       nginx,
+		conf:        conf,
+		files:       []string{conf, filepath.Join(dir, "conf", "deis.conf")},
+		lastGoodDir: filepath.Join(dir, "last-good"),
+		setStatus:   func(s string) { *status = s },
+	}
+	os.MkdirAll(filepath.Dir(conf), 0755)
+	return r, status, func() { os.RemoveAll(dir) }
+}
+
+func TestConfigReloaderRollback(t *testing.T) {
+	r, status, cleanup := newTestReloader(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(r.conf, []byte("worker_processes 1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if *status != configOK {
+		t.Errorf("expected status %q, got %q", configOK, *status)
+	}
+
+	if err := ioutil.WriteFile(r.conf, []byte("bad;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("expected the config to be rejected")
+	}
+	if !strings.Contains(*status, "unknown directive") {
+		t.Errorf("expected the validation error as status, got %q", *status)
+	}
+	data, err := ioutil.ReadFile(r.conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "worker_processes 1;" {
+		t.Errorf("expected the last good config to be restored, got %q", data)
+	}
+}
+
+func TestConfigReloaderWithoutLastGood(t *testing.T) {
+	r, status, cleanup := newTestReloader(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(r.conf, []byte("bad;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reload(); err == nil {
+		t.Fatal("expected the config to be rejected")
+	}
+	if *status == configOK {
+		t.Errorf("expected an error status")
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// templateDir holds the templates of the files rendered from etcd.
+const templateDir string = "/etc/router/templates"
+
+// templateResource is a file rendered from a template in templateDir.
+type templateResource struct {
+	src  string
+	dest string
+	mode os.FileMode
+	// run is true for scripts which are run whenever they change.
+	run bool
+}
+
+// templateResources are the files rendered by boot. nginx is reloaded whenever any of them
+// changes.
+var templateResources = []*templateResource{
+	{src: "nginx.conf", dest: nginxConf, mode: 0644},
+	{src: "deis.conf", dest: "/opt/nginx/conf/deis.conf", mode: 0644},
+	{src: "ssl.conf", dest: "/opt/nginx/conf/ssl.conf", mode: 0644},
+	{src: "deis.cert", dest: "/etc/ssl/deis.cert", mode: 0644},
+	{src: "deis.key", dest: "/etc/ssl/deis.key", mode: 0644},
+	{src: "dhparam.pem", dest: "/etc/ssl/dhparam.pem", mode: 0644},
+	// writes the certificates of custom domains, which nginx.conf refers to
+	{src: "generate-certs", dest: "/bin/generate-certs", mode: 0755, run: true},
+}
+
+// watchedPrefixes are the etcd prefixes the templates read from.
+var watchedPrefixes = []string{
+	"/deis/config",
+	"/deis/services",
+	"/deis/ports",
+	"/deis/draining",
+	"/deis/router",
+	"/deis/domains",
+	"/deis/controller",
+	"/deis/builder",
+	"/deis/store/gateway",
+	"/deis/certs",
+}
+
+// ignoredPrefixes are keys under watchedPrefixes which change often and are not used by the
+// templates.
+var ignoredPrefixes = []string{
+	"/deis/router/hosts/",
+	"/deis/router/status/",
+	"/deis/router/acme/challenges/",
+	"/deis/router/acme/lock",
+}
+
+// kvPair is a key and its value, as returned by the gets template function.
+type kvPair struct {
+	Key   string
+	Value string
+}
+
+// store is a snapshot of etcd keys and their values, which templates are rendered from.
+type store map[string]string
+
+// funcMap returns the template functions. Apart from tcpRoutes and upstreams, they behave like
+// the confd functions of the same name.
+func (s store) funcMap() template.FuncMap {
+	return template.FuncMap{
+		"getv":      s.getv,
+		"gets":      s.gets,
+		"exists":    s.exists,
+		"ls":        s.ls,
+		"lsdir":     s.lsdir,
+		"base":      path.Base,
+		"split":     strings.Split,
+		"tcpRoutes": s.tcpRoutes,
+		"upstreams": s.upstreams,
+	}
+}
+
+// getv returns the value of key, or the default value if the key does not exist. A missing
+// key without a default is an error.
+func (s store) getv(key string, dfault ...string) (string, error) {
+	value, ok := s[key]
+	if !ok {
+		if len(dfault) > 0 {
+			return dfault[0], nil
+		}
+		return "", fmt.Errorf("key does not exist: %s", key)
+	}
+	return value, nil
+}
+
+// gets returns the keys matching a pattern such as /deis/services/go/*, sorted by key.
+func (s store) gets(pattern string) ([]kvPair, error) {
+	var pairs []kvPair
+	for key, value := range s {
+		matched, err := path.Match(pattern, key)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			pairs = append(pairs, kvPair{Key: key, Value: value})
+		}
+	}
+	sort.Sort(byKey(pairs))
+	return pairs, nil
+}
+
+func (s store) exists(key string) bool {
+	_, ok := s[key]
+	return ok
+}
+
+// ls returns the sorted names of the keys and directories in dir.
+func (s store) ls(dir string) []string {
+	return s.children(dir, false)
+}
+
+// lsdir returns the sorted names of the directories in dir.
+func (s store) lsdir(dir string) []string {
+	return s.children(dir, true)
+}
+
+func (s store) children(dir string, dirsOnly bool) []string {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	seen := make(map[string]bool)
+	var names []string
+	for key := range s {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		name := strings.SplitN(rest, "/", 2)[0]
+		isDir := strings.Contains(rest, "/")
+		if seen[name] || (dirsOnly && !isDir) {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type byKey []kvPair
+
+func (p byKey) Len() int           { return len(p) }
+func (p byKey) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byKey) Less(i, j int) bool { return p[i].Key < p[j].Key }
+
+// render renders a template with the keys in the store.
+func (s store) render(name, text string) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(s.funcMap()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderResources renders every resource whose output differs from rendered, the output of the
+// last successful render by destination. It returns whether nginx needs to be reloaded.
+//
+// Nothing is written unless every template renders, and rendered is only updated once every
+// changed resource is written, so that a failed render is retried in full.
+func renderResources(s store, dir string, resources []*templateResource, rendered map[string][]byte) (bool, error) {
+	outs := make([][]byte, len(resources))
+	for i, r := range resources {
+		text, err := ioutil.ReadFile(filepath.Join(dir, r.src))
+		if err != nil {
+			return false, err
+		}
+		if outs[i], err = s.render(r.src, string(text)); err != nil {
+			return false, err
+		}
+	}
+	changed := make(map[string][]byte)
+	for i, r := range resources {
+		out := outs[i]
+		if prev, ok := rendered[r.dest]; ok && bytes.Equal(prev, out) {
+			continue
+		}
+		if err := writeFileAtomic(r.dest, out, r.mode); err != nil {
+			return false, err
+		}
+		log.Debugf("rendered %s", r.dest)
+		changed[r.dest] = out
+		if r.run {
+			if out, err := exec.Command(r.dest).CombinedOutput(); err != nil {
+				log.Warnf("%s terminated by error: %v: %s", r.dest, err, out)
+			}
+		}
+	}
+	for dest, out := range changed {
+		rendered[dest] = out
+	}
+	return len(changed) > 0, nil
+}
+
+// templateRenderer renders templateResources from etcd whenever the keys under
+// watchedPrefixes change, and reloads nginx with the new config.
+type templateRenderer struct {
+	client    *etcd.Client
+	dir       string
+	resources []*templateResource
+	reloader  *configReloader
+	// debounce is how long to wait for more changes before rendering.
+	debounce time.Duration
+
+	rendered map[string][]byte
+	changes  chan struct{}
+	stop     chan bool
+}
+
+func newTemplateRenderer(client *etcd.Client, reloader *configReloader) *templateRenderer {
+	return &templateRenderer{
+		client:    client,
+		dir:       templateDir,
+		resources: templateResources,
+		reloader:  reloader,
+		debounce:  500 * time.Millisecond,
+		rendered:  make(map[string][]byte),
+		changes:   make(chan struct{}, 1),
+		stop:      make(chan bool),
+	}
+}
+
+// watch starts watching etcd for changes.
+func (r *templateRenderer) watch() {
+	for _, prefix := range watchedPrefixes {
+		go r.watchPrefix(prefix)
+	}
+}
+
+// renderUntilValid renders the templates until nginx accepts the config.
+func (r *templateRenderer) renderUntilValid() {
+	for {
+		err := r.render()
+		if err == nil {
+			return
+		}
+		log.Info("waiting for valid config to be rendered...")
+		log.Debug(err)
+		time.Sleep(timeout)
+	}
+}
+
+// run renders the templates whenever etcd changes, until stopWatching is called.
+func (r *templateRenderer) run() {
+	for {
+		select {
+		case <-r.changes:
+		case <-r.stop:
+			return
+		}
+		// wait for related changes, e.g. every key of a new release, to be rendered at once
+		settled := time.After(r.debounce)
+	debounce:
+		for {
+			select {
+			case <-r.changes:
+			case <-settled:
+				break debounce
+			}
+		}
+		if err := r.render(); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+// stopWatching stops watching etcd and rendering templates.
+func (r *templateRenderer) stopWatching() {
+	close(r.stop)
+}
+
+// render renders the templates from the current keys in etcd, reloading nginx if they changed.
+func (r *templateRenderer) render() error {
+	s, err := r.snapshot()
+	if err != nil {
+		return err
+	}
+	reload, err := renderResources(s, r.dir, r.resources, r.rendered)
+	if err != nil || !reload {
+		return err
+	}
+	if err := r.reloader.reload(); err != nil {
+		// the last good config was restored, so render everything again next time
+		r.rendered = make(map[string][]byte)
+		return err
+	}
+	return nil
+}
+
+// snapshot reads every key under watchedPrefixes.
+func (r *templateRenderer) snapshot() (store, error) {
+	s := make(store)
+	for _, prefix := range watchedPrefixes {
+		resp, err := r.client.Get(prefix, false, true)
+		if err != nil {
+			if strings.Contains(err.Error(), "Key not found") {
+				continue
+			}
+			return nil, err
+		}
+		addNodes(s, resp.Node)
+	}
+	return s, nil
+}
+
+func addNodes(s store, node *etcd.Node) {
+	if !node.Dir {
+		s[node.Key] = node.Value
+		return
+	}
+	for _, child := range node.Nodes {
+		addNodes(s, child)
+	}
+}
+
+// watchPrefix notifies run of every change under prefix, until stopWatching is called.
+func (r *templateRenderer) watchPrefix(prefix string) {
+	var index uint64
+	for {
+		resp, err := r.client.Watch(prefix, index, true, nil, r.stop)
+		if err == etcd.ErrWatchStoppedByUser {
+			return
+		}
+		if err != nil {
+			log.Debugf("watching %s: %v", prefix, err)
+			if index != 0 {
+				// changes may have been missed, render again from scratch
+				index = 0
+				r.notify()
+			}
+			time.Sleep(time.Second)
+			continue
+		}
+		index = resp.Node.ModifiedIndex + 1
+		if !isIgnoredKey(resp.Node.Key) {
+			r.notify()
+		}
+	}
+}
+
+func (r *templateRenderer) notify() {
+	select {
+	case r.changes <- struct{}{}:
+	default:
+	}
+}
+
+func isIgnoredKey(key string) bool {
+	for _, prefix := range ignoredPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic replaces path with data, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testKeys = store{
+	"/deis/controller/host":                  "10.0.0.1",
+	"/deis/controller/port":                  "8000",
+	"/deis/builder/host":                     "10.0.0.2",
+	"/deis/builder/port":                     "2223",
+	"/deis/router/workerProcesses":           "4",
+	"/deis/services/go/go_v2.web.1":          "10.0.0.3:32768",
+	"/deis/services/go/go_v2.web.2":          "10.0.0.4:32769",
+	"/deis/services/ruby/ruby_v1.web.1":      "10.0.0.5:32770",
+	"/deis/ports/go/web/5000/go_v2.web.1":    "10.0.0.3:32768",
+	"/deis/draining/services/go/go_v2.web.2": "true",
+	"/deis/domains/www.example.org":          "ruby",
+	"/deis/certs/www.example.org/cert":       "cert",
+	"/deis/certs/www.example.org/key":        "key",
+	"/deis/config/ruby/deis_whitelist":       "10.0.0.0/8",
+	"/deis/router/acme/challenges/token":     "keyauth",
+	"/deis/router/hosts/10.0.0.1":            "10.0.0.1:80",
+	"/deis/router/status/10.0.0.1":           "ok",
+}
+
+func TestStoreGetv(t *testing.T) {
+	if v, err := testKeys.getv("/deis/router/workerProcesses", "auto"); v != "4" || err != nil {
+		t.Errorf("expected 4, got %q, %v", v, err)
+	}
+	if v, err := testKeys.getv("/deis/router/serverTokens", "on"); v != "on" || err != nil {
+		t.Errorf("expected the default, got %q, %v", v, err)
+	}
+	if _, err := testKeys.getv("/deis/router/serverTokens"); err == nil {
+		t.Errorf("expected an error for a missing key without a default")
+	}
+}
+
+func TestStoreGets(t *testing.T) {
+	pairs, err := testKeys.gets("/deis/services/go/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []kvPair{
+		{Key: "/deis/services/go/go_v2.web.1", Value: "10.0.0.3:32768"},
+		{Key: "/deis/services/go/go_v2.web.2", Value: "10.0.0.4:32769"},
+	}
+	if !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("expected %v, got %v", expected, pairs)
+	}
+	if _, err := testKeys.gets("/deis/services/["); err == nil {
+		t.Errorf("expected an error for a malformed pattern")
+	}
+}
+
+func TestStoreLs(t *testing.T) {
+	if names := testKeys.lsdir("/deis/services"); !reflect.DeepEqual(names, []string{"go", "ruby"}) {
+		t.Errorf("unexpected directories %v", names)
+	}
+	if names := testKeys.ls("/deis/controller/"); !reflect.DeepEqual(names, []string{"host", "port"}) {
+		t.Errorf("unexpected keys %v", names)
+	}
+	if names := testKeys.lsdir("/deis/controller"); len(names) != 0 {
+		t.Errorf("expected no directories, got %v", names)
+	}
+	if names := testKeys.ls("/deis/missing"); len(names) != 0 {
+		t.Errorf("expected no keys, got %v", names)
+	}
+}
+
+func TestRenderNginxConf(t *testing.T) {
+	text, err := ioutil.ReadFile("../../rootfs/etc/router/templates/nginx.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := testKeys.render("nginx.conf", string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := string(out)
+	for _, line := range []string{
+		"worker_processes 4;",
+		"upstream go {",
+		"server 10.0.0.3:32768;",
+		"server 10.0.0.4:32769 down;",
+		"server_name www.example.org;",
+		"set $deis_app ruby;",
+		`server_name ~^go--web--5000\.(?<domain>.+)$;`,
+	} {
+		if !strings.Contains(conf, line) {
+			t.Errorf("expected %q in the rendered config", line)
+		}
+	}
+	if strings.Contains(conf, "<no value>") {
+		t.Errorf("the rendered config contains missing values")
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	s := store{"/deis/router/serverTokens": "off"}
+	for _, r := range templateResources {
+		text, err := ioutil.ReadFile(filepath.Join("../../rootfs/etc/router/templates", r.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.render(r.src, string(text)); err != nil {
+			t.Errorf("%s: %v", r.src, err)
+		}
+	}
+}
+
+func TestRenderResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "router")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.conf"), []byte(`app {{ getv "/app" }};`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ran := filepath.Join(dir, "ran")
+	script := "#!/bin/sh\necho {{ getv \"/app\" }} >> " + ran + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "script"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "conf", "app.conf")
+	resources := []*templateResource{
+		{src: "app.conf", dest: dest, mode: 0644},
+		{src: "script", dest: filepath.Join(dir, "bin", "script"), mode: 0755, run: true},
+	}
+	rendered := make(map[string][]byte)
+
+	reload, err := renderResources(store{"/app": "go"}, dir, resources, rendered)
+	if err != nil || !reload {
+		t.Fatalf("expected the resources to be rendered, got %v, %v", reload, err)
+	}
+	if data, _ := ioutil.ReadFile(dest); string(data) != "app go;" {
+		t.Errorf("unexpected output %q", data)
+	}
+	if _, err := os.Stat(dest + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the temporary file to be renamed")
+	}
+
+	// unchanged output is neither written nor run again
+	reload, err = renderResources(store{"/app": "go"}, dir, resources, rendered)
+	if err != nil || reload {
+		t.Errorf("expected nothing to be rendered, got %v, %v", reload, err)
+	}
+	if data, _ := ioutil.ReadFile(ran); string(data) != "go\n" {
+		t.Errorf("expected the script to run once, got %q", data)
+	}
+
+	reload, err = renderResources(store{"/app": "ruby"}, dir, resources, rendered)
+	if err != nil || !reload {
+		t.Errorf("expected the resources to be rendered, got %v, %v", reload, err)
+	}
+	if data, _ := ioutil.ReadFile(ran); string(data) != "go\nruby\n" {
+		t.Errorf("expected the script to run again, got %q", data)
+	}
+	// a failed render writes nothing, and is rendered again in full
+	if err := ioutil.WriteFile(filepath.Join(dir, "script"), []byte(`{{ getv "/missing" }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reload, err = renderResources(store{"/app": "php"}, dir, resources, rendered)
+	if err == nil || reload {
+		t.Errorf("expected the render to fail, got %v, %v", reload, err)
+	}
+	if data, _ := ioutil.ReadFile(dest); string(data) != "app ruby;" {
+		t.Errorf("expected %s to be kept, got %q", dest, data)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "script"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reload, err = renderResources(store{"/app": "php"}, dir, resources, rendered)
+	if err != nil || !reload {
+		t.Errorf("expected the resources to be rendered, got %v, %v", reload, err)
+	}
+}
+
+func TestIsIgnoredKey(t *testing.T) {
+	for key, ignored := range map[string]bool{
+		"/deis/router/hosts/10.0.0.1":        true,
+		"/deis/router/acme/challenges/token": true,
+		"/deis/router/acme/lock":             true,
+		"/deis/router/acme/enabled":          false,
+		"/deis/services/go/go_v2.web.1":      false,
+	} {
+		if isIgnoredKey(key) != ignored {
+			t.Errorf("expected isIgnoredKey(%q) to be %v", key, ignored)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// process is a long running child of boot which is restarted whenever it exits.
+type process struct {
+	name string
+	// command returns the command used to (re)start the process.
+	command func() *exec.Cmd
+	// started, if set, is called once the process has started. done is closed when it exits.
+	started func(done <-chan struct{})
+	// stopped, if set, is called once the process has exited.
+	stopped func()
+}
+
+// supervisor restarts processes when they exit, backing off between restarts. If a process
+// keeps failing, the supervisor gives up and reports an error on its failed channel.
+type supervisor struct {
+	// minBackoff is the delay before the first restart, doubled on every consecutive failure.
+	minBackoff time.Duration
+	// maxBackoff caps the delay between restarts.
+	maxBackoff time.Duration
+	// maxFailures is the number of consecutive failures after which the supervisor gives up.
+	maxFailures int
+	// stableAfter is how long a process must run before its failures are forgotten.
+	stableAfter time.Duration
+
+	failed chan error
+
+	mu      sync.Mutex
+	running map[string]*runningProcess
+	stopped map[string]bool
+}
+
+// runningProcess is a started process. done is closed when it exits.
+type runningProcess struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+func newSupervisor() *supervisor {
+	return &supervisor{
+		minBackoff:  time.Second,
+		maxBackoff:  30 * time.Second,
+		maxFailures: 5,
+		stableAfter: time.Minute,
+		failed:      make(chan error, 1),
+		running:     make(map[string]*runningProcess),
+		stopped:     make(map[string]bool),
+	}
+}
+
+// supervise runs the process until it fails too many times in a row or is stopped.
+func (s *supervisor) supervise(p *process) {
+	failures := 0
+	backoff := s.minBackoff
+	for !s.isStopped(p.name) {
+		start := time.Now()
+		err := s.run(p)
+		if s.isStopped(p.name) {
+			log.Infof("%s stopped", p.name)
+			return
+		}
+		if time.Since(start) >= s.stableAfter {
+			failures = 0
+			backoff = s.minBackoff
+		}
+		failures++
+		if err != nil {
+			log.Warnf("%s terminated by error: %v", p.name, err)
+		} else {
+			log.Warnf("%s exited", p.name)
+		}
+		if failures >= s.maxFailures {
+			s.fail(fmt.Errorf("%s failed %d times in a row, giving up", p.name, failures))
+			return
+		}
+		log.Infof("restarting %s in %v...", p.name, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// run starts the process and waits for it to exit.
+func (s *supervisor) run(p *process) error {
+	cmd := p.command()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	s.mu.Lock()
+	s.running[p.name] = &runningProcess{cmd: cmd, done: done}
+	s.mu.Unlock()
+	if p.started != nil {
+		go p.started(done)
+	}
+	err := cmd.Wait()
+	s.mu.Lock()
+	delete(s.running, p.name)
+	s.mu.Unlock()
+	close(done)
+	if p.stopped != nil {
+		p.stopped()
+	}
+	return err
+}
+
+// stop stops restarting the process and sends it sig, killing it if it has not exited after the
+// timeout.
+func (s *supervisor) stop(name string, sig os.Signal, timeout time.Duration) {
+	s.mu.Lock()
+	s.stopped[name] = true
+	p := s.running[name]
+	s.mu.Unlock()
+	if p == nil {
+		return
+	}
+
+	if err := p.cmd.Process.Signal(sig); err != nil {
+		log.Warnf("could not signal %s: %v", name, err)
+	}
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		log.Warnf("%s did not exit after %v, killing it", name, timeout)
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+func (s *supervisor) isStopped(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped[name]
+}
+
+// fail reports the first process the supervisor gave up on.
+func (s *supervisor) fail(err error) {
+	select {
+	case s.failed <- err:
+	default:
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSupervisorGivesUp(t *testing.T) {
+	s := newSupervisor()
+	s.minBackoff = time.Millisecond
+	s.maxFailures = 3
+
+	var stops int
+	go s.supervise(&process{
+		name:    "false",
+		command: func() *exec.Cmd { return exec.Command("false") },
+		stopped: func() { stops++ },
+	})
+
+	select {
+	case err := <-s.failed:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor did not give up")
+	}
+	if stops != 3 {
+		t.Errorf("expected the process to be restarted until it failed 3 times, got %d", stops)
+	}
+}
+
+func TestSupervisorRestartsStableProcess(t *testing.T) {
+	s := newSupervisor()
+	s.minBackoff = time.Millisecond
+	s.maxFailures = 2
+	s.stableAfter = 0
+
+	stopped := make(chan bool, 10)
+	go s.supervise(&process{
+		name:    "true",
+		command: func() *exec.Cmd { return exec.Command("true") },
+		stopped: func() { stopped <- true },
+	})
+
+	// a process which ran long enough is restarted no matter how many times it exits
+	for i := 0; i < 5; i++ {
+		select {
+		case <-stopped:
+		case err := <-s.failed:
+			t.Fatalf("supervisor gave up: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("process was not restarted")
+		}
+	}
+}
+
+func TestSupervisorStartError(t *testing.T) {
+	s := newSupervisor()
+	s.minBackoff = time.Millisecond
+	s.maxFailures = 1
+
+	go s.supervise(&process{
+		name:    "missing",
+		command: func() *exec.Cmd { return exec.Command("/nonexistent/binary") },
+	})
+	select {
+	case <-s.failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the supervisor to give up on a process which cannot start")
+	}
+}
+
+func TestSupervisorStop(t *testing.T) {
+	s := newSupervisor()
+	s.minBackoff = time.Millisecond
+
+	started := make(chan bool, 1)
+	stopped := make(chan bool, 1)
+	go func() {
+		s.supervise(&process{
+			name:    "sleep",
+			command: func() *exec.Cmd { return exec.Command("sleep", "60") },
+			started: func(done <-chan struct{}) { started <- true },
+		})
+		stopped <- true
+	}()
+	<-started
+
+	s.stop("sleep", syscall.SIGTERM, 5*time.Second)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stopped process should not be restarted")
+	}
+	select {
+	case err := <-s.failed:
+		t.Errorf("stopping a process is not a failure: %v", err)
+	default:
+	}
+}
+
+func TestSupervisorStopTimeout(t *testing.T) {
+	s := newSupervisor()
+	started := make(chan bool, 1)
+	go s.supervise(&process{
+		name: "trap",
+		command: func() *exec.Cmd {
+			return exec.Command("sh", "-c", `trap "" TERM; while true; do sleep 1; done`)
+		},
+		started: func(done <-chan struct{}) { started <- true },
+	})
+	<-started
+	time.Sleep(100 * time.Millisecond)
+
+	begin := time.Now()
+	s.stop("trap", syscall.SIGTERM, 100*time.Millisecond)
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("expected the process to be killed after the timeout, took %v", elapsed)
+	}
+}
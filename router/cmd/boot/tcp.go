@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+)
+
+// reservedPorts are the ports the router already listens on, which apps cannot route TCP
+// traffic through.
+var reservedPorts = map[int]bool{
+	80:   true, // http
+	443:  true, // https
+	2222: true, // builder
+	9090: true, // health check
+	9091: true, // request metrics
+	9092: true, // ACME challenges
+}
+
+// tcpRoute is a port the router proxies raw TCP connections from to one or more apps.
+type tcpRoute struct {
+	Port int
+	// Passthrough is true when the apps terminate TLS themselves. Several such apps may share a
+	// port, in which case connections are routed by the server name sent in the TLS handshake.
+	Passthrough bool
+	Apps        []*tcpApp
+}
+
+// tcpApp is an app routed to by a tcpRoute.
+type tcpApp struct {
+	Name string
+	// Upstream is the name of the nginx upstream of the app's containers.
+	Upstream string
+	Servers  []upstreamServer
+	// ServerNames are the TLS server names routed to the app: its custom domains, and
+	// <app>.<domain> for any domain.
+	ServerNames []string
+	// Whitelist is the app's deis_whitelist setting.
+	Whitelist string
+}
+
+// tcpRoutes returns the TCP routes of the apps which set /deis/config/<app>/tcp_port, sorted by
+// port. Apps with an invalid port, or which cannot share the port they ask for, are left out
+// rather than breaking the rest of the router's config.
+func (s store) tcpRoutes() ([]*tcpRoute, error) {
+	routes := make(map[int]*tcpRoute)
+	for _, name := range s.lsdir("/deis/config") {
+		value, ok := s["/deis/config/"+name+"/tcp_port"]
+		if !ok {
+			continue
+		}
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 || reservedPorts[port] {
+			log.Warnf("ignoring tcp_port %q of %s: it must be a free port between 1 and 65535", value, name)
+			continue
+		}
+		app, err := s.tcpApp(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(app.Servers) == 0 {
+			continue
+		}
+		passthrough := s["/deis/config/"+name+"/tls_passthrough"] == "true"
+
+		route, ok := routes[port]
+		if !ok {
+			routes[port] = &tcpRoute{Port: port, Passthrough: passthrough, Apps: []*tcpApp{app}}
+			continue
+		}
+		if !route.Passthrough || !passthrough {
+			log.Warnf("ignoring tcp_port %d of %s: it is used by %s and only apps with tls_passthrough can share a port", port, name, route.Apps[0].Name)
+			continue
+		}
+		// the whitelist of one app cannot be applied before the server name is known
+		if app.Whitelist != "" || route.Apps[0].Whitelist != "" {
+			log.Warnf("ignoring tcp_port %d of %s: apps with a deis_whitelist cannot share a port", port, name)
+			continue
+		}
+		route.Apps = append(route.Apps, app)
+	}
+
+	var ports []int
+	for port := range routes {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	sorted := make([]*tcpRoute, len(ports))
+	for i, port := range ports {
+		sorted[i] = routes[port]
+	}
+	return sorted, nil
+}
+
+// tcpApp looks up the containers and domains of an app.
+func (s store) tcpApp(name string) (*tcpApp, error) {
+	app := &tcpApp{
+		Name:        name,
+		Upstream:    "tcp-" + name,
+		ServerNames: []string{"~^" + name + `\.`},
+		Whitelist:   s["/deis/config/"+name+"/deis_whitelist"],
+	}
+	servers, err := s.upstreams(name, "/deis/services/"+name)
+	if err != nil {
+		return nil, err
+	}
+	app.Servers = servers
+	for _, domain := range s.ls("/deis/domains") {
+		if s["/deis/domains/"+domain] == name {
+			app.ServerNames = append(app.ServerNames, domain)
+		}
+	}
+	return app, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var tcpKeys = store{
+	"/deis/config/mqtt/tcp_port":                 "1883",
+	"/deis/config/mqtt/deis_whitelist":           "10.0.0.0/8:office",
+	"/deis/services/mqtt/mqtt_v1.web.1":          "10.0.0.3:32768",
+	"/deis/services/mqtt/mqtt_v1.web.2":          "10.0.0.4:32769",
+	"/deis/draining/services/mqtt/mqtt_v1.web.2": "true",
+	"/deis/config/db/tcp_port":                   "8443",
+	"/deis/config/db/tls_passthrough":            "true",
+	"/deis/services/db/db_v1.web.1":              "10.0.0.5:32770",
+	"/deis/config/cache/tcp_port":                "8443",
+	"/deis/config/cache/tls_passthrough":         "true",
+	"/deis/services/cache/cache_v1.web.1":        "10.0.0.6:32771",
+	"/deis/domains/cache.example.org":            "cache",
+	// another app cannot take over mqtt's port
+	"/deis/config/queue/tcp_port":         "1883",
+	"/deis/services/queue/queue_v1.web.1": "10.0.0.7:32772",
+	// apps without containers are not routed
+	"/deis/config/idle/tcp_port": "9000",
+	// reserved and invalid ports are ignored
+	"/deis/config/web/tcp_port":           "80",
+	"/deis/services/web/web_v1.web.1":     "10.0.0.8:32773",
+	"/deis/config/bogus/tcp_port":         "mqtt",
+	"/deis/services/bogus/bogus_v1.web.1": "10.0.0.9:32774",
+}
+
+func TestTCPRoutes(t *testing.T) {
+	routes, err := tcpKeys.tcpRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*tcpRoute{
+		{
+			Port: 1883,
+			Apps: []*tcpApp{{
+				Name:     "mqtt",
+				Upstream: "tcp-mqtt",
+				Servers: []upstreamServer{
+					{Addr: "10.0.0.3:32768"},
+					{Addr: "10.0.0.4:32769", Down: true},
+				},
+				ServerNames: []string{`~^mqtt\.`},
+				Whitelist:   "10.0.0.0/8:office",
+			}},
+		},
+		{
+			Port:        8443,
+			Passthrough: true,
+			Apps: []*tcpApp{
+				{
+					Name:        "cache",
+					Upstream:    "tcp-cache",
+					Servers:     []upstreamServer{{Addr: "10.0.0.6:32771"}},
+					ServerNames: []string{`~^cache\.`, "cache.example.org"},
+				},
+				{
+					Name:        "db",
+					Upstream:    "tcp-db",
+					Servers:     []upstreamServer{{Addr: "10.0.0.5:32770"}},
+					ServerNames: []string{`~^db\.`},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("unexpected routes:")
+		for _, r := range routes {
+			t.Errorf("%+v", *r)
+			for _, a := range r.Apps {
+				t.Errorf("  %+v", *a)
+			}
+		}
+	}
+}
+
+func TestTCPRoutesWhitelistCannotShare(t *testing.T) {
+	s := store{
+		"/deis/config/db/tcp_port":            "8443",
+		"/deis/config/db/tls_passthrough":     "true",
+		"/deis/services/db/db_v1.web.1":       "10.0.0.5:32770",
+		"/deis/config/cache/tcp_port":         "8443",
+		"/deis/config/cache/tls_passthrough":  "true",
+		"/deis/config/cache/deis_whitelist":   "10.0.0.0/8",
+		"/deis/services/cache/cache_v1.web.1": "10.0.0.6:32771",
+	}
+	routes, err := s.tcpRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || len(routes[0].Apps) != 1 || routes[0].Apps[0].Name != "cache" {
+		t.Errorf("expected only the first app to be routed, got %+v", routes)
+	}
+}
+
+func TestRenderTCPRoutes(t *testing.T) {
+	text, err := ioutil.ReadFile("../../rootfs/etc/router/templates/nginx.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tcpKeys.render("nginx.conf", string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := string(out)
+	for _, line := range []string{
+		"upstream tcp-mqtt {",
+		"server 10.0.0.4:32769 down;",
+		"listen 1883;",
+		"allow 10.0.0.0/8;  # office",
+		"proxy_pass tcp-mqtt;",
+		"map $ssl_preread_server_name $tcp_8443_upstream {",
+		`~^db\. tcp-db;`,
+		"cache.example.org tcp-cache;",
+		"listen 8443;",
+		"ssl_preread on;",
+		"proxy_pass $tcp_8443_upstream;",
+	} {
+		if !strings.Contains(conf, line) {
+			t.Errorf("expected %q in the rendered config", line)
+		}
+	}
+	for _, line := range []string{"tcp-queue", "tcp-idle", "tcp-web", "tcp-bogus"} {
+		if strings.Contains(conf, line) {
+			t.Errorf("expected no %q in the rendered config", line)
+		}
+	}
+}
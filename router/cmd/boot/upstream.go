@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionRegex matches the release version in a container name such as go_v2.web.1.
+var versionRegex = regexp.MustCompile(`_v([1-9][0-9]*)\.`)
+
+// upstreamServer is a container nginx proxies to.
+type upstreamServer struct {
+	Addr string
+	// Weight is the nginx weight of the server, or 0 for the default weight.
+	Weight int
+	// Down is true while the container is draining.
+	Down bool
+}
+
+// upstreams returns the containers published under dir, e.g. /deis/services/<app>, sorted by
+// container name.
+//
+// While an application sets canary_weight, its containers of older releases stay published and
+// the containers of the newest release are weighted to receive canary_weight percent of the
+// requests.
+func (s store) upstreams(app, dir string) ([]upstreamServer, error) {
+	containers, err := s.gets(strings.TrimSuffix(dir, "/") + "/*")
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]upstreamServer, len(containers))
+	versions := make([]int, len(containers))
+	newest := 0
+	for i, c := range containers {
+		servers[i] = upstreamServer{
+			Addr: c.Value,
+			Down: s.exists("/deis/draining/" + strings.TrimPrefix(c.Key, "/deis/")),
+		}
+		if match := versionRegex.FindStringSubmatch(path.Base(c.Key)); match != nil {
+			versions[i], _ = strconv.Atoi(match[1])
+		}
+		if versions[i] > newest {
+			newest = versions[i]
+		}
+	}
+
+	weight := s.canaryWeight(app)
+	if weight == 0 {
+		return servers, nil
+	}
+	// split the weight evenly between the servers of each side, ignoring those which are draining
+	var canaries, others int
+	for i, server := range servers {
+		if server.Down {
+			continue
+		}
+		if versions[i] == newest {
+			canaries++
+		} else {
+			others++
+		}
+	}
+	if canaries == 0 || others == 0 {
+		return servers, nil
+	}
+	canaryWeight, otherWeight := weight*others, (100-weight)*canaries
+	divisor := gcd(canaryWeight, otherWeight)
+	for i := range servers {
+		if versions[i] == newest {
+			servers[i].Weight = canaryWeight / divisor
+		} else {
+			servers[i].Weight = otherWeight / divisor
+		}
+	}
+	return servers, nil
+}
+
+// canaryWeight returns the percentage of requests the newest release of an application
+// receives, or 0 if the application is not being canaried.
+func (s store) canaryWeight(app string) int {
+	value, ok := s["/deis/config/"+app+"/canary_weight"]
+	if !ok {
+		return 0
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 1 || weight > 99 {
+		log.Warnf("ignoring canary_weight %q of %s: it must be between 1 and 99", value, app)
+		return 0
+	}
+	return weight
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
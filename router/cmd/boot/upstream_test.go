@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var canaryKeys = store{
+	"/deis/config/go/canary_weight":          "10",
+	"/deis/services/go/go_v2.web.1":          "10.0.0.1:32768",
+	"/deis/services/go/go_v2.web.2":          "10.0.0.2:32768",
+	"/deis/services/go/go_v2.web.3":          "10.0.0.3:32768",
+	"/deis/services/go/go_v2.web.4":          "10.0.0.4:32768",
+	"/deis/services/go/go_v3.web.1":          "10.0.0.5:32768",
+	"/deis/draining/services/go/go_v2.web.4": "true",
+	"/deis/ports/go/web/5000/go_v2.web.1":    "10.0.0.1:32769",
+	"/deis/ports/go/web/5000/go_v3.web.1":    "10.0.0.5:32769",
+}
+
+func TestUpstreams(t *testing.T) {
+	s := store{
+		"/deis/services/go/go_v2.web.1":          "10.0.0.1:32768",
+		"/deis/services/go/go_v2.web.2":          "10.0.0.2:32768",
+		"/deis/draining/services/go/go_v2.web.2": "true",
+	}
+	servers, err := s.upstreams("go", "/deis/services/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []upstreamServer{
+		{Addr: "10.0.0.1:32768"},
+		{Addr: "10.0.0.2:32768", Down: true},
+	}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("expected %v, got %v", expected, servers)
+	}
+}
+
+func TestUpstreamsCanary(t *testing.T) {
+	servers, err := canaryKeys.upstreams("go", "/deis/services/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// three healthy v2 containers share 90% and the v3 container gets 10%
+	expected := []upstreamServer{
+		{Addr: "10.0.0.1:32768", Weight: 3},
+		{Addr: "10.0.0.2:32768", Weight: 3},
+		{Addr: "10.0.0.3:32768", Weight: 3},
+		{Addr: "10.0.0.4:32768", Weight: 3, Down: true},
+		{Addr: "10.0.0.5:32768", Weight: 1},
+	}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("expected %v, got %v", expected, servers)
+	}
+}
+
+func TestUpstreamsCanarySingleVersion(t *testing.T) {
+	s := store{
+		"/deis/config/go/canary_weight": "10",
+		"/deis/services/go/go_v3.web.1": "10.0.0.5:32768",
+	}
+	servers, err := s.upstreams("go", "/deis/services/go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].Weight != 0 {
+		t.Errorf("expected an unweighted server, got %v", servers)
+	}
+}
+
+func TestCanaryWeight(t *testing.T) {
+	for value, expected := range map[string]int{
+		"10":  10,
+		"99":  99,
+		"0":   0,
+		"100": 0,
+		"ten": 0,
+	} {
+		s := store{"/deis/config/go/canary_weight": value}
+		if weight := s.canaryWeight("go"); weight != expected {
+			t.Errorf("expected canary_weight %q to be %d, got %d", value, expected, weight)
+		}
+	}
+	if weight := (store{}).canaryWeight("go"); weight != 0 {
+		t.Errorf("expected no canary, got %d", weight)
+	}
+}
+
+func TestRenderCanary(t *testing.T) {
+	text, err := ioutil.ReadFile("../../rootfs/etc/router/templates/nginx.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := canaryKeys.render("nginx.conf", string(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := string(out)
+	for _, line := range []string{
+		"server 10.0.0.1:32768 weight=3;",
+		"server 10.0.0.4:32768 weight=3 down;",
+		"server 10.0.0.5:32768 weight=1;",
+		// one container of each version is published on port 5000
+		"server 10.0.0.1:32769 weight=9;",
+		"server 10.0.0.5:32769 weight=1;",
+	} {
+		if !strings.Contains(conf, line) {
+			t.Errorf("expected %q in the rendered config", line)
+		}
+	}
+}
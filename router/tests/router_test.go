@@ -25,6 +25,7 @@ func TestRouter(t *testing.T) {
 		"/deis/router",
 		"/deis/database",
 		"/deis/services",
+		"/deis/ports",
 		"/deis/builder",
 		"/deis/domains",
 		"/deis/store",